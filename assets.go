@@ -0,0 +1,117 @@
+package pepper
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/iktech/pepper/controllers"
+	"github.com/iktech/pepper/model"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// fingerprintLength is the number of hexadecimal digits of the content hash
+// inserted into fingerprinted file names.
+const fingerprintLength = 8
+
+var fingerprinted = regexp.MustCompile(`^(.+)\.([0-9a-f]{8})(\.[^./]+)$`)
+
+// assetManifest is the controller serving the asset manifest as JSON.
+type assetManifest struct {
+	static *staticFileHandler
+}
+
+func init() {
+	model.Functions["asset"] = Asset
+}
+
+// Asset returns the URL of a static file. When fingerprinting is enabled the
+// URL contains the content hash, for example /app.3f9a1c2b.css for app.css,
+// so that the file can be cached forever. It is available to templates as
+// the "asset" function.
+func Asset(name string) string {
+	name = strings.TrimPrefix(name, "/")
+	if site.staticHandler == nil {
+		return "/" + name
+	}
+	return "/" + site.staticHandler.fingerprint(name)
+}
+
+// fingerprint returns the fingerprinted name of the file, or the name itself
+// if the file does not exist or is not fingerprinted.
+func (h *staticFileHandler) fingerprint(name string) string {
+	if !h.isFingerprinted(name) {
+		return name
+	}
+	etag := h.etag(name)
+	if etag == "" {
+		return name
+	}
+
+	ext := path.Ext(name)
+	return strings.TrimSuffix(name, ext) + "." + strings.Trim(etag, `"`)[:fingerprintLength] + ext
+}
+
+// original returns the name of the file the fingerprinted name refers to.
+// Names with an outdated hash are not resolved.
+func (h *staticFileHandler) original(name string) (string, bool) {
+	m := fingerprinted.FindStringSubmatch(name)
+	if m == nil {
+		return "", false
+	}
+
+	original := m[1] + m[3]
+	if !h.isFingerprinted(original) || h.fingerprint(original) != name {
+		return "", false
+	}
+	return original, true
+}
+
+func (h *staticFileHandler) isFingerprinted(name string) bool {
+	for _, pattern := range h.assetPatterns {
+		if matchPattern(pattern, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// manifest maps the names of all fingerprinted static files to their
+// fingerprinted names.
+func (h *staticFileHandler) manifest() map[string]string {
+	manifest := make(map[string]string)
+	_ = fs.WalkDir(h.root, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		if hashed := h.fingerprint(name); hashed != name {
+			manifest[name] = hashed
+		}
+		return nil
+	})
+	return manifest
+}
+
+// addAssetManifest registers the asset manifest at the configured path.
+func addAssetManifest(routerMap map[string]controllers.Controller, static *staticFileHandler, name string) {
+	name = strings.TrimPrefix(name, "/")
+	if _, found := routerMap[name]; found {
+		slog.Warn("asset manifest is shadowed by a controller", "path", name, KeyComponent, ComponentService)
+		return
+	}
+	routerMap[name] = assetManifest{static: static}
+}
+
+func (a assetManifest) Handle(_ *http.Request) (int, string, string, *bytes.Buffer, *model.ProcessingError) {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(a.static.manifest()); err != nil {
+		slog.Error("cannot encode asset manifest", KeyError, err, KeyComponent, ComponentService)
+		return 0, "", "", nil, &model.ProcessingError{ResponseCode: 500}
+	}
+	return 200, "", "application/json", &buf, nil
+}
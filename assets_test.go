@@ -0,0 +1,140 @@
+package pepper
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+)
+
+const immutable = "public, max-age=31536000, immutable"
+
+// hashed returns the name fingerprinted with the hash of the content.
+func hashed(base, ext, content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return base + "." + hex.EncodeToString(sum[:])[:fingerprintLength] + ext
+}
+
+func assetHandler() *staticFileHandler {
+	root := fstest.MapFS{
+		"css/app.css":        {Data: []byte("body{}")},
+		"img/logo.png":       {Data: []byte("png")},
+		"robots.txt":         {Data: []byte("User-agent: *")},
+		"js/lib.0123abcd.js": {Data: []byte("vendored")},
+		"js/lib.js":          {Data: []byte("lib")},
+		"fonts/empty.woff2":  {Data: []byte("")},
+	}
+	h := newStaticHandler(root, []CacheRule{{Pattern: "*.css", Control: "no-cache"}, {Pattern: "*.js", Control: "max-age=60"}}, true)
+	h.assetPatterns = []string{"*.css", "*.png", "*.js", "*.woff2"}
+	h.assetControl = immutable
+	return h
+}
+
+func TestAssetFingerprint(t *testing.T) {
+	h := assetHandler()
+	css := hashed("css/app", ".css", "body{}")
+
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"css/app.css", css},
+		{"img/logo.png", hashed("img/logo", ".png", "png")},
+		{"robots.txt", "robots.txt"},
+		{"css/missing.css", "css/missing.css"},
+	}
+	for _, tt := range tests {
+		if got := h.fingerprint(tt.name); got != tt.want {
+			t.Errorf("fingerprint(%s) = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+
+	if original, ok := h.original(css); !ok || original != "css/app.css" {
+		t.Errorf("original(%s) = %s, %v, want css/app.css", css, original, ok)
+	}
+	for _, name := range []string{"css/app.00000000.css", "robots.0123abcd.txt", "css/app.css"} {
+		if original, ok := h.original(name); ok {
+			t.Errorf("original(%s) = %s, want none", name, original)
+		}
+	}
+
+	previous := site
+	t.Cleanup(func() { site = previous })
+	site = Service{}
+	if got := Asset("/css/app.css"); got != "/css/app.css" {
+		t.Errorf("Asset() without static handler = %s, want /css/app.css", got)
+	}
+	site.staticHandler = h
+	if got := Asset("/css/app.css"); got != "/"+css {
+		t.Errorf("Asset() = %s, want /%s", got, css)
+	}
+}
+
+func TestAssetManifest(t *testing.T) {
+	h := assetHandler()
+	want := map[string]string{
+		"css/app.css":        hashed("css/app", ".css", "body{}"),
+		"img/logo.png":       hashed("img/logo", ".png", "png"),
+		"js/lib.js":          hashed("js/lib", ".js", "lib"),
+		"js/lib.0123abcd.js": hashed("js/lib.0123abcd", ".js", "vendored"),
+		"fonts/empty.woff2":  hashed("fonts/empty", ".woff2", ""),
+	}
+
+	_, _, contentType, buf, pe := assetManifest{static: h}.Handle(nil)
+	if pe != nil {
+		t.Fatal(pe)
+	}
+	if contentType != "application/json" {
+		t.Errorf("content type = %s, want application/json", contentType)
+	}
+	var got map[string]string
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(want) {
+		t.Errorf("manifest = %v, want %v", got, want)
+	}
+	for name, hashedName := range want {
+		if got[name] != hashedName {
+			t.Errorf("manifest[%s] = %s, want %s", name, got[name], hashedName)
+		}
+	}
+}
+
+func TestAssetCacheControl(t *testing.T) {
+	h := assetHandler()
+
+	tests := []struct {
+		name    string
+		path    string
+		code    int
+		body    string
+		control string
+	}{
+		{"fingerprinted", "/" + hashed("css/app", ".css", "body{}"), http.StatusOK, "body{}", immutable},
+		{"original", "/css/app.css", http.StatusOK, "body{}", "no-cache"},
+		{"outdated hash", "/css/app.00000000.css", http.StatusNotFound, "", ""},
+		{"file named like a fingerprint", "/js/lib.0123abcd.js", http.StatusOK, "vendored", "max-age=60"},
+		{"not an asset", "/robots.txt", http.StatusOK, "User-agent: *", ""},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", tt.path, nil))
+		if w.Code != tt.code {
+			t.Errorf("%s: status %d, want %d", tt.name, w.Code, tt.code)
+			continue
+		}
+		if tt.code != http.StatusOK {
+			continue
+		}
+		if w.Body.String() != tt.body {
+			t.Errorf("%s: body = %q, want %q", tt.name, w.Body.String(), tt.body)
+		}
+		if got := w.Header().Get("Cache-Control"); got != tt.control {
+			t.Errorf("%s: Cache-Control = %q, want %q", tt.name, got, tt.control)
+		}
+	}
+}
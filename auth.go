@@ -0,0 +1,279 @@
+package pepper
+
+import (
+	"github.com/iktech/pepper/authentication"
+	"github.com/iktech/pepper/controllers"
+	"github.com/iktech/pepper/model"
+	"github.com/spf13/viper"
+	"log/slog"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Authentication schemes of auth rules.
+const (
+	AuthSchemeBasic = authentication.BasicAuthScheme
+	AuthSchemeNone  = "none"
+)
+
+// passwordHandlers holds the handler of every password file in use, so that
+// each file is loaded and watched once however many routes it protects.
+var passwordHandlers = make(map[string]*authentication.BasicAuthHandler)
+
+// exporting is set while CreateExport builds the site. Protected routes are
+// not exported, so their password files are not loaded.
+var exporting bool
+
+// loginThrottle limits failed logins across all password files; it is nil
+// if http.auth.throttle.enabled is not set.
+var loginThrottle *authentication.Throttle
+
+// AuthRule protects the requests it matches: those below a path prefix, for
+// a group of routes or for a static mount, given by its prefix. Routes ending
+// with "*" match by prefix. The scheme is basic, or none to leave requests
+// matched by a later rule public. Users are checked against the password file
+// of the auth group, or http.password.file if no group is given; if users are
+// listed, only they are admitted.
+type AuthRule struct {
+	Name   string   `mapstructure:"name"`
+	Prefix string   `mapstructure:"prefix"`
+	Routes []string `mapstructure:"routes"`
+	Mount  string   `mapstructure:"mount"`
+	Scheme string   `mapstructure:"scheme"`
+	Realm  string   `mapstructure:"realm"`
+	Group  string   `mapstructure:"group"`
+	Users  []string `mapstructure:"users"`
+}
+
+// authRule is a configured rule with the middleware enforcing it.
+type authRule struct {
+	AuthRule
+	protect func(http.Handler) http.Handler
+}
+
+// newBasicAuthHandler returns a handler checking credentials against a
+// password file, reloaded when the file changes or, if
+// http.password.reloadInterval is set, checked for changes at that interval.
+// With http.password.upgrade, hashes other than bcrypt are upgraded on login
+// to bcrypt hashes of cost http.password.cost. Refused requests get the
+// error page or problem details like any other error.
+func newBasicAuthHandler() *authentication.BasicAuthHandler {
+	return &authentication.BasicAuthHandler{
+		PollInterval:  viper.GetDuration("http.password.reloadInterval"),
+		UpgradeHashes: viper.GetBool("http.password.upgrade"),
+		Cost:          viper.GetInt("http.password.cost"),
+		Realm:         viper.GetString("http.auth.realm"),
+		Throttle:      loginThrottle,
+		Roles:         userRoles(),
+		WriteError: func(w http.ResponseWriter, r *http.Request, code int) {
+			writeError(w, r, model.ProcessingError{ResponseCode: code})
+		},
+	}
+}
+
+// newLoginThrottle returns the throttle of failed logins configured by
+// http.auth.throttle, or nil unless http.auth.throttle.enabled is set. After
+// maxFailures failed logins of a user, from any client address, logins of
+// that user are rejected with 429 for baseDelay, doubled with every further
+// failure up to maxDelay; likewise logins from a client address after
+// maxFailuresPerIP failed logins from it. Counters are forgotten after window without failures. Clients
+// from the addresses in allow are not throttled.
+func newLoginThrottle() *authentication.Throttle {
+	if !viper.GetBool("http.auth.throttle.enabled") {
+		return nil
+	}
+	return &authentication.Throttle{
+		MaxFailures:      viper.GetInt("http.auth.throttle.maxFailures"),
+		MaxFailuresPerIP: viper.GetInt("http.auth.throttle.maxFailuresPerIP"),
+		BaseDelay:        viper.GetDuration("http.auth.throttle.baseDelay"),
+		MaxDelay:         viper.GetDuration("http.auth.throttle.maxDelay"),
+		Window:           viper.GetDuration("http.auth.throttle.window"),
+		Allow:            allowList("http.auth.throttle.allow"),
+		ClientIP:         clientIP,
+	}
+}
+
+// passwordHandler returns the handler of the password file.
+func passwordHandler(file string) *authentication.BasicAuthHandler {
+	handler, found := passwordHandlers[file]
+	if !found {
+		handler = newBasicAuthHandler()
+		passwordHandlers[file] = handler
+	}
+	return handler
+}
+
+// userRoles reads the roles of the users from http.auth.roles, which lists
+// the users of every role, for example editor: [alice, bob]. The roles of a
+// user are the same whichever password file authenticates them. Role names
+// are lower case, as configuration keys are not case sensitive.
+func userRoles() map[string][]string {
+	roles := make(map[string][]string)
+	for role, users := range viper.GetStringMapStringSlice("http.auth.roles") {
+		for _, user := range users {
+			roles[user] = append(roles[user], role)
+		}
+	}
+	for _, r := range roles {
+		sort.Strings(r)
+	}
+	return roles
+}
+
+// basicAuth returns the middleware requiring the credentials of the auth
+// group, or of http.password.file if the group is empty, challenging with
+// the realm and admitting only the users listed, if any.
+func basicAuth(group, realm string, users []string) func(http.Handler) http.Handler {
+	if exporting {
+		return refuse
+	}
+	file := viper.GetString("http.password.file")
+	if group != "" {
+		file = authGroupFile(group)
+	}
+	if realm == "" {
+		realm = viper.GetString("http.auth.realm")
+	}
+	return passwordHandler(file).Restrict(file, realm, users)
+}
+
+// refuse is the middleware of protected routes while exporting, answering
+// every request with 401 without asking for credentials.
+func refuse(http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, r, model.ProcessingError{ResponseCode: http.StatusUnauthorized})
+	})
+}
+
+// authGroupFile returns the password file of the auth group, as defined by
+// http.auth.groups.<name>.file.
+func authGroupFile(name string) string {
+	file := viper.GetString("http.auth.groups." + name + ".file")
+	if file == "" {
+		slog.Error("auth group has no password file", "group", name, KeyComponent, ComponentService)
+		os.Exit(1)
+	}
+	return file
+}
+
+// authGroup returns the middleware requiring the credentials of the auth
+// group.
+func authGroup(name string) func(http.Handler) http.Handler {
+	return basicAuth(name, "", nil)
+}
+
+// loadAuthRules reads the rules from http.auth.rules, followed by a rule for
+// every controller implementing controllers.Protected.
+func loadAuthRules(routerMap map[string]controllers.Controller, mounts []staticMount) []authRule {
+	var config []AuthRule
+	if err := viper.UnmarshalKey("http.auth.rules", &config); err != nil {
+		slog.Error("cannot read auth rules", KeyError, err, KeyComponent, ComponentService)
+		os.Exit(1)
+	}
+
+	rules := make([]authRule, 0, len(config))
+	for _, c := range config {
+		rule := authRule{AuthRule: c}
+		rule.Prefix = strings.Trim(c.Prefix, "/")
+		if c.Mount != "" {
+			mount := strings.Trim(c.Mount, "/")
+			if !hasMount(mounts, mount) {
+				slog.Error("auth rule refers to an unknown static mount", "rule", c.Name, "mount", c.Mount, KeyComponent, ComponentService)
+				os.Exit(1)
+			}
+			if rule.Prefix != "" && rule.Prefix != mount {
+				slog.Error("auth rule has both a prefix and a static mount", "rule", c.Name, KeyComponent, ComponentService)
+				os.Exit(1)
+			}
+			rule.Prefix = mount
+		}
+		if rule.Prefix == "" && len(rule.Routes) == 0 {
+			slog.Error("auth rule has no prefix, routes or static mount", "rule", c.Name, KeyComponent, ComponentService)
+			os.Exit(1)
+		}
+
+		switch strings.ToLower(c.Scheme) {
+		case "", AuthSchemeBasic:
+			rule.Scheme = AuthSchemeBasic
+			rule.protect = basicAuth(c.Group, c.Realm, c.Users)
+		case AuthSchemeNone:
+			rule.Scheme = AuthSchemeNone
+		default:
+			slog.Error("unexpected scheme in auth rule", "rule", c.Name, "scheme", c.Scheme, KeyComponent, ComponentService)
+			os.Exit(1)
+		}
+
+		slog.Info("protecting requests", "rule", c.Name, "prefix", "/"+rule.Prefix, "routes", c.Routes, "scheme", rule.Scheme, "group", c.Group, KeyComponent, ComponentService)
+		rules = append(rules, rule)
+	}
+
+	keys := make([]string, 0, len(routerMap))
+	for key := range routerMap {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if p, ok := routerMap[key].(controllers.Protected); ok {
+			protection := p.Protection()
+			rule := authRule{
+				AuthRule: AuthRule{Name: "controller", Routes: []string{key}, Scheme: AuthSchemeBasic, Realm: protection.Realm, Group: protection.Group, Users: protection.Users},
+				protect:  basicAuth(protection.Group, protection.Realm, protection.Users),
+			}
+			slog.Info("protecting requests", "route", "/"+key, "scheme", rule.Scheme, "group", protection.Group, KeyComponent, ComponentService)
+			rules = append(rules, rule)
+		}
+	}
+	return rules
+}
+
+func hasMount(mounts []staticMount, prefix string) bool {
+	for _, m := range mounts {
+		if m.prefix == prefix {
+			return true
+		}
+	}
+	return false
+}
+
+// authRule returns the first rule matching the path, if any.
+func (s Service) authRule(name string) (authRule, bool) {
+	for _, rule := range s.authRules {
+		if rule.matches(name) {
+			return rule, true
+		}
+	}
+	return authRule{}, false
+}
+
+// protected reports whether requests for the path require authentication,
+// either by an auth rule or because a protected static mount serves it.
+func (s Service) protected(name string) bool {
+	if rule, found := s.authRule(name); found && rule.protect != nil {
+		return true
+	}
+	mount, mounted := s.mount(name)
+	return mounted && mount.protected
+}
+
+func (r authRule) matches(name string) bool {
+	if r.Prefix != "" && name != r.Prefix && !strings.HasPrefix(name, r.Prefix+"/") {
+		return false
+	}
+
+	if len(r.Routes) == 0 {
+		return true
+	}
+	for _, route := range r.Routes {
+		route = strings.TrimPrefix(route, "/")
+		if prefix, found := strings.CutSuffix(route, "*"); found {
+			if strings.HasPrefix(name, prefix) {
+				return true
+			}
+		} else if strings.Trim(route, "/") == name {
+			return true
+		}
+	}
+	return false
+}
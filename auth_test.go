@@ -0,0 +1,120 @@
+package pepper
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/iktech/pepper/authentication"
+	"github.com/iktech/pepper/controllers"
+	"github.com/iktech/pepper/model"
+	"github.com/spf13/viper"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// authService returns a service protecting everything below admin with the
+// password file, holding alice with the password "secret" and the roles
+// admin and editor.
+func authService(t *testing.T) Service {
+	t.Helper()
+	t.Cleanup(viper.Reset)
+	previousHandlers, previousThrottle := passwordHandlers, loginThrottle
+	passwordHandlers, loginThrottle = make(map[string]*authentication.BasicAuthHandler), nil
+	t.Cleanup(func() {
+		for _, handler := range passwordHandlers {
+			handler.Close()
+		}
+		passwordHandlers, loginThrottle = previousHandlers, previousThrottle
+	})
+
+	file := filepath.Join(t.TempDir(), ".passwd")
+	writeFile(t, file, "alice:{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=\n")
+	viper.Set("http.password.file", file)
+	viper.Set("http.auth.realm", authentication.DefaultRealm)
+	viper.Set("http.auth.rules", []map[string]interface{}{{"name": "admin", "prefix": "admin"}})
+	viper.Set("http.auth.roles", map[string][]string{"editor": {"alice", "bob"}, "admin": {"alice"}})
+	return Service{authRules: loadAuthRules(nil, nil)}
+}
+
+func TestUnauthorisedErrorPage(t *testing.T) {
+	s := authService(t)
+	previous := ErrorPages
+	t.Cleanup(func() { ErrorPages = previous })
+
+	static := t.TempDir()
+	writeFile(t, filepath.Join(static, "401.html"), "please log in")
+	viper.Set("http.content.useEmbedded", false)
+	viper.Set("http.content.staticDirectory", static)
+	ErrorPages = map[int]*ErrorPageDefinition{401: {Name: "401.html"}}
+
+	tests := []struct {
+		name        string
+		accept      string
+		contentType string
+		body        string
+	}{
+		{name: "browser", accept: "text/html", contentType: contentTypeHTML, body: "please log in"},
+		{name: "api client", accept: "application/json", contentType: contentTypeJSON},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/admin/users", nil)
+		r.Header.Set("Accept", tt.accept)
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("%s: status %d, want %d", tt.name, w.Code, http.StatusUnauthorized)
+		}
+		if got, want := w.Header().Get("WWW-Authenticate"), `Basic realm="Restricted", charset="UTF-8"`; got != want {
+			t.Errorf("%s: challenge = %q, want %q", tt.name, got, want)
+		}
+		if got := w.Header().Get("Content-Type"); got != tt.contentType {
+			t.Errorf("%s: content type = %q, want %q", tt.name, got, tt.contentType)
+		}
+		if tt.body != "" && w.Body.String() != tt.body {
+			t.Errorf("%s: body = %q, want %q", tt.name, w.Body.String(), tt.body)
+		}
+	}
+}
+
+func TestPrincipalOfProtectedRoute(t *testing.T) {
+	s := authService(t)
+
+	templates := t.TempDir()
+	writeFile(t, filepath.Join(templates, "admin.gohtml"), `{{ with principal }}{{ .User }} {{ .Scheme }} {{ .Roles }}{{ end }}`)
+	s.routerMap = map[string]controllers.Controller{
+		"admin": controllers.Model{Model: &model.Model{Template: "admin.gohtml", TemplatesDirectory: os.DirFS(templates)}},
+	}
+
+	var log bytes.Buffer
+	previous := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&log, nil)))
+	t.Cleanup(func() { slog.SetDefault(previous) })
+
+	r := httptest.NewRequest("GET", "/admin", nil)
+	r.SetBasicAuth("alice", "secret")
+	w := httptest.NewRecorder()
+	Logging()(s).ServeHTTP(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d, want %d", w.Code, http.StatusOK)
+	}
+	if got, want := w.Body.String(), "alice basic [admin editor]"; got != want {
+		t.Errorf("page = %q, want %q", got, want)
+	}
+
+	var user interface{}
+	for _, line := range strings.Split(strings.TrimSpace(log.String()), "\n") {
+		var record map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &record); err == nil && record["msg"] == "http server request" {
+			user = record["user"]
+		}
+	}
+	if user != "alice" {
+		t.Errorf("access log user = %v, want alice", user)
+	}
+}
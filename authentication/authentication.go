@@ -1,103 +1,415 @@
 package authentication
 
 import (
-	"bufio"
 	"fmt"
+	"github.com/fsnotify/fsnotify"
+	"github.com/iktech/pepper/identity"
 	"golang.org/x/crypto/bcrypt"
 	"log/slog"
 	"net/http"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
+// retryInterval is the minimum time between attempts to load a password
+// file which could not be read.
+const retryInterval = 5 * time.Second
+
+// DefaultRealm is the realm of the challenge if the handler has none.
+const DefaultRealm = "Restricted"
+
+// BasicAuthHandler checks HTTP basic authentication credentials against a
+// password file. The file is loaded when the middleware is created and
+// reloaded when it changes, either as reported by the file system or, if
+// PollInterval is set, by checking it periodically. A handler serves a
+// single password file.
 type BasicAuthHandler struct {
-	Credentials map[string]string
-	Loaded      bool
+	PollInterval time.Duration
+	// UpgradeHashes replaces hashes of other schemes by bcrypt hashes in
+	// the password file when users log in successfully.
+	UpgradeHashes bool
+	// Cost is the bcrypt cost of upgraded hashes, bcrypt.DefaultCost if 0.
+	Cost int
+	// Realm is sent in the challenge of BasicAuth, DefaultRealm if empty.
+	Realm string
+	// Throttle, if set, limits the rate of failed logins.
+	Throttle *Throttle
+	// Roles are the roles of the principals authenticated by the handler,
+	// keyed by user.
+	Roles map[string][]string
+	// WriteError, if set, writes the response to refused requests, whose
+	// WWW-Authenticate or Retry-After header is already set. Only the status
+	// is written otherwise.
+	WriteError func(rw http.ResponseWriter, rq *http.Request, code int)
+
+	mu          sync.RWMutex
+	credentials map[string]string
+	loaded      bool
+	once        sync.Once
+	path        string
+	lastAttempt time.Time
+	modTime     time.Time
+	size        int64
+	done        chan struct{}
+	closeOnce   sync.Once
+	dummyOnce   sync.Once
+	dummy       string
 }
 
 func (bah *BasicAuthHandler) BasicAuth(path string) func(handler http.Handler) http.Handler {
+	return bah.Restrict(path, bah.Realm, nil)
+}
+
+// Restrict returns a middleware like BasicAuth which challenges clients with
+// the realm and only admits the users listed, or every user of the password
+// file if none are. Users who are not listed are challenged again, so that
+// browsers let them log in as another user.
+func (bah *BasicAuthHandler) Restrict(path, realm string, users []string) func(handler http.Handler) http.Handler {
+	if realm == "" {
+		realm = DefaultRealm
+	}
+	var allowed map[string]bool
+	if len(users) > 0 {
+		allowed = make(map[string]bool, len(users))
+		for _, user := range users {
+			allowed[user] = true
+		}
+	}
+
+	bah.once.Do(func() {
+		bah.path = path
+		bah.done = make(chan struct{})
+		bah.load()
+		if path != "" {
+			if bah.PollInterval > 0 {
+				go bah.poll()
+			} else {
+				go bah.watch()
+			}
+		}
+	})
+	if path != bah.path {
+		slog.Warn("password file differs from the one of the handler", "file", path, "handler_file", bah.path, "component", "authenticator")
+	}
+
 	return func(handler http.Handler) http.Handler {
 		return http.HandlerFunc(func(rw http.ResponseWriter, rq *http.Request) {
-			if !bah.Loaded {
-				bah.Credentials = make(map[string]string)
-				if path != "" {
-					file, err := os.Open(path)
-					if err != nil {
-						fmt.Printf("cannot open password file '%s': file does not exist\n", path)
-						unauthorised(rw)
-						return
-					}
-					defer file.Close()
-
-					scanner := bufio.NewScanner(file)
-					for scanner.Scan() {
-						line := strings.TrimSpace(scanner.Text())
-						if line != "" && !strings.HasPrefix(line, "#") {
-							parts := strings.Split(line, ":")
-							if len(parts) == 2 {
-								bah.Credentials[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
-							}
-						}
-					}
-				}
-				bah.Loaded = true
+			if !bah.Loaded() {
+				bah.retry()
 			}
 
 			u, p, ok := rq.BasicAuth()
 			if !ok || len(strings.TrimSpace(u)) < 1 || len(strings.TrimSpace(p)) < 1 {
-				unauthorised(rw)
+				bah.unauthorised(rw, rq, realm)
 				return
 			}
 
-			passwd := bah.Credentials[u]
+			var (
+				ip      string
+				trusted bool
+			)
+			if bah.Throttle != nil {
+				ip, trusted = bah.Throttle.clientIP(rq)
+				if !trusted {
+					if wait, key := bah.Throttle.locked(u, ip); wait > 0 {
+						ThrottledTotal.WithLabelValues(key).Inc()
+						slog.Warn("login rejected while locked out", "event", "auth_throttled", "key", key, "user", u, "ip_address", ip, "retry_after", wait.Round(time.Second), "component", "authenticator")
+						bah.tooManyRequests(rw, rq, wait)
+						return
+					}
+				}
+			}
+
+			passwd := bah.credential(u)
 			if passwd == "" {
-				unauthorised(rw)
+				// The password is checked anyway, so that unknown users
+				// cannot be told apart by the time taken to refuse them.
+				Verify(bah.dummyHash(), []byte(p))
+				FailuresTotal.WithLabelValues("unknown_user").Inc()
+				slog.Warn("login of unknown user", "event", "auth_failure", "reason", "unknown_user", "user", u, "ip_address", ip, "realm", realm, "component", "authenticator")
+				if bah.Throttle != nil && !trusted {
+					bah.Throttle.failure(u, ip, false)
+				}
+				bah.unauthorised(rw, rq, realm)
 				return
 			}
 
-			// This is a dummy check for credentials.
-			if !comparePasswords(passwd, []byte(p)) {
-				unauthorised(rw)
+			if !Verify(passwd, []byte(p)) {
+				FailuresTotal.WithLabelValues("invalid_password").Inc()
+				slog.Warn("invalid password", "event", "auth_failure", "reason", "invalid_password", "user", u, "ip_address", ip, "realm", realm, "component", "authenticator")
+				if bah.Throttle != nil && !trusted {
+					bah.Throttle.failure(u, ip, true)
+				}
+				bah.unauthorised(rw, rq, realm)
 				return
 			}
+			if bah.Throttle != nil {
+				bah.Throttle.success(u)
+			}
+			if allowed != nil && !allowed[u] {
+				slog.Info("user is not allowed", "user", u, "realm", realm, "component", "authenticator")
+				bah.unauthorised(rw, rq, realm)
+				return
+			}
+			if bah.UpgradeHashes && Scheme(passwd) != SchemeBcrypt {
+				bah.upgrade(u, passwd, []byte(p))
+			}
 
-			// If required, Context could be updated to include authentication
-			// related data so that it could be used in consequent steps.
-			handler.ServeHTTP(rw, rq)
+			principal := &identity.Principal{User: u, Scheme: BasicAuthScheme, Realm: realm, Roles: bah.Roles[u]}
+			handler.ServeHTTP(rw, rq.WithContext(authenticated(rq.Context(), principal)))
 		})
 	}
 }
 
-func unauthorised(rw http.ResponseWriter) {
-	rw.Header().Set("WWW-Authenticate", "Basic realm=Restricted")
-	rw.WriteHeader(http.StatusUnauthorized)
+// Close stops watching the password file for changes.
+func (bah *BasicAuthHandler) Close() {
+	bah.closeOnce.Do(func() {
+		if bah.done != nil {
+			close(bah.done)
+		}
+	})
 }
 
-func HashAndSalt(pwd []byte) string {
+// Loaded reports whether the password file has been read. It is false while
+// the file is missing or unreadable and no credentials have been loaded.
+func (bah *BasicAuthHandler) Loaded() bool {
+	bah.mu.RLock()
+	defer bah.mu.RUnlock()
+	return bah.loaded
+}
+
+func (bah *BasicAuthHandler) credential(user string) string {
+	bah.mu.RLock()
+	defer bah.mu.RUnlock()
+	return bah.credentials[user]
+}
+
+// retry loads a password file which could not be read before, at most once
+// per retry interval.
+func (bah *BasicAuthHandler) retry() {
+	bah.mu.RLock()
+	due := time.Since(bah.lastAttempt) >= retryInterval
+	bah.mu.RUnlock()
+	if due {
+		bah.load()
+	}
+}
 
-	// Use GenerateFromPassword to hash & salt pwd.
-	// MinCost is just an integer constant provided by the bcrypt
-	// package along with DefaultCost & MaxCost.
-	// The cost can be any value you want provided it isn't lower
-	// than the MinCost (4)
-	hash, err := bcrypt.GenerateFromPassword(pwd, bcrypt.MinCost)
+// load reads the password file and replaces the credentials. If the file
+// does not exist, the credentials are cleared so that deleting the file locks
+// everybody out; if it cannot be read otherwise, the credentials loaded
+// before are kept.
+func (bah *BasicAuthHandler) load() {
+	credentials := make(map[string]string)
+	var (
+		modTime time.Time
+		size    int64
+	)
+	if bah.path != "" {
+		file, err := os.Open(bah.path)
+		if err != nil {
+			slog.Error("cannot open password file", "file", bah.path, "error", err, "component", "authenticator")
+			bah.mu.Lock()
+			bah.lastAttempt = time.Now()
+			if os.IsNotExist(err) {
+				bah.credentials, bah.loaded = nil, false
+			}
+			bah.mu.Unlock()
+			return
+		}
+		defer file.Close()
+
+		if info, err := file.Stat(); err == nil {
+			modTime, size = info.ModTime(), info.Size()
+		}
+
+		entries, err := ParsePasswordFile(file, bah.path)
+		if err != nil {
+			slog.Error("cannot read password file", "file", bah.path, "error", err, "component", "authenticator")
+			bah.mu.Lock()
+			bah.lastAttempt = time.Now()
+			bah.mu.Unlock()
+			return
+		}
+		for user, entry := range entries {
+			credentials[user] = entry.Hash
+		}
+	}
+
+	bah.mu.Lock()
+	defer bah.mu.Unlock()
+	bah.credentials = credentials
+	bah.loaded = true
+	bah.lastAttempt = time.Now()
+	bah.modTime, bah.size = modTime, size
+	slog.Info("loaded password file", "file", bah.path, "users", len(credentials), "component", "authenticator")
+}
+
+// watch reloads the password file whenever it changes. The directory is
+// watched rather than the file, so that files replaced by renaming, as
+// editors and Kubernetes secret volumes do, keep being watched.
+func (bah *BasicAuthHandler) watch() {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		slog.Error("cannot watch password file, falling back to polling", "file", bah.path, "error", err, "component", "authenticator")
+		bah.PollInterval = time.Minute
+		bah.poll()
+		return
+	}
+	defer watcher.Close()
+
+	dir := filepath.Dir(bah.path)
+	if err := watcher.Add(dir); err != nil {
+		slog.Error("cannot watch password file, falling back to polling", "file", bah.path, "error", err, "component", "authenticator")
+		bah.PollInterval = time.Minute
+		bah.poll()
+		return
+	}
+
+	name := filepath.Clean(bah.path)
+	for {
+		select {
+		case <-bah.done:
+			return
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			// Kubernetes swaps a symbolic link to the data directory, so any
+			// change to the directory may change the file.
+			if filepath.Clean(event.Name) == name || strings.HasPrefix(filepath.Base(event.Name), "..") {
+				if event.Has(fsnotify.Write) || event.Has(fsnotify.Create) || event.Has(fsnotify.Rename) || event.Has(fsnotify.Remove) {
+					bah.reloadIfChanged()
+				}
+			}
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			slog.Warn("error while watching password file", "file", bah.path, "error", err, "component", "authenticator")
+		}
+	}
+}
+
+// poll reloads the password file when its size or modification time change.
+func (bah *BasicAuthHandler) poll() {
+	ticker := time.NewTicker(bah.PollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-bah.done:
+			return
+		case <-ticker.C:
+			bah.reloadIfChanged()
+		}
+	}
+}
+
+func (bah *BasicAuthHandler) reloadIfChanged() {
+	info, err := os.Stat(bah.path)
+	if err != nil {
+		// A deleted file revokes the credentials. Files replaced by renaming
+		// are never missing, so this does not happen during updates.
+		if os.IsNotExist(err) && bah.Loaded() {
+			bah.load()
+		}
+		return
+	}
+
+	bah.mu.RLock()
+	changed := !bah.loaded || !info.ModTime().Equal(bah.modTime) || info.Size() != bah.size
+	bah.mu.RUnlock()
+	if changed {
+		bah.load()
+	}
+}
+
+func (bah *BasicAuthHandler) unauthorised(rw http.ResponseWriter, rq *http.Request, realm string) {
+	rw.Header().Set("WWW-Authenticate", "Basic realm="+strconv.Quote(realm)+`, charset="UTF-8"`)
+	bah.writeError(rw, rq, http.StatusUnauthorized)
+}
+
+func (bah *BasicAuthHandler) tooManyRequests(rw http.ResponseWriter, rq *http.Request, wait time.Duration) {
+	rw.Header().Set("Retry-After", strconv.Itoa(int((wait+time.Second-1)/time.Second)))
+	bah.writeError(rw, rq, http.StatusTooManyRequests)
+}
+
+func (bah *BasicAuthHandler) writeError(rw http.ResponseWriter, rq *http.Request, code int) {
+	if bah.WriteError != nil {
+		bah.WriteError(rw, rq, code)
+		return
+	}
+	rw.WriteHeader(code)
+}
+
+// HashAndSalt returns the bcrypt hash of the password computed with
+// bcrypt.DefaultCost, or an empty string if it cannot be computed.
+func HashAndSalt(pwd []byte) string {
+	hash, err := HashAndSaltWithCost(pwd, bcrypt.DefaultCost)
 	if err != nil {
 		slog.Error("cannot generate hash from password", "error", err, "component", "authenticator")
 	}
-	// GenerateFromPassword returns a byte slice so we need to
-	// convert the bytes to a string and return it
-	return string(hash)
+	return hash
 }
 
-func comparePasswords(hashedPwd string, plainPwd []byte) bool {
-	// Since we'll be getting the hashed password from the DB it
-	// will be a string so we'll need to convert it to a byte slice
-	byteHash := []byte(hashedPwd)
-	err := bcrypt.CompareHashAndPassword(byteHash, plainPwd)
+// HashAndSaltWithCost returns the bcrypt hash of the password computed with
+// the cost, which must be between bcrypt.MinCost and bcrypt.MaxCost.
+func HashAndSaltWithCost(pwd []byte, cost int) (string, error) {
+	if cost < bcrypt.MinCost || cost > bcrypt.MaxCost {
+		return "", fmt.Errorf("bcrypt cost %d is not between %d and %d", cost, bcrypt.MinCost, bcrypt.MaxCost)
+	}
+	hash, err := bcrypt.GenerateFromPassword(pwd, cost)
 	if err != nil {
-		slog.Error("cannot compare hash", "error", err, "component", "authenticator")
-		return false
+		return "", err
 	}
+	return string(hash), nil
+}
 
-	return true
+// cost returns the bcrypt cost of new hashes.
+func (bah *BasicAuthHandler) cost() int {
+	if bah.Cost == 0 {
+		return bcrypt.DefaultCost
+	}
+	return bah.Cost
+}
+
+// dummyHash returns a bcrypt hash of the configured cost to check the
+// passwords of unknown users against.
+func (bah *BasicAuthHandler) dummyHash() string {
+	bah.dummyOnce.Do(func() {
+		var err error
+		bah.dummy, err = HashAndSaltWithCost([]byte("unknown user"), bah.cost())
+		if err != nil {
+			slog.Error("cannot generate hash from password", "error", err, "component", "authenticator")
+		}
+	})
+	return bah.dummy
+}
+
+// upgrade replaces the hash of the user by a bcrypt hash of the password,
+// both in memory and in the password file.
+func (bah *BasicAuthHandler) upgrade(user, previous string, password []byte) {
+	hash, err := HashAndSaltWithCost(password, bah.cost())
+	if err != nil {
+		slog.Error("cannot generate hash from password", "error", err, "component", "authenticator")
+		return
+	}
+
+	bah.mu.Lock()
+	if bah.credentials[user] != previous {
+		bah.mu.Unlock()
+		return
+	}
+	bah.credentials[user] = hash
+	bah.mu.Unlock()
+
+	if err := UpdatePasswordFile(bah.path, user, hash); err != nil {
+		slog.Error("cannot upgrade password hash", "file", bah.path, "user", user, "error", err, "component", "authenticator")
+		return
+	}
+	slog.Info("upgraded password hash to bcrypt", "file", bah.path, "user", user, "scheme", Scheme(previous), "component", "authenticator")
 }
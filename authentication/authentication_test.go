@@ -0,0 +1,236 @@
+package authentication
+
+import (
+	"github.com/iktech/pepper/identity"
+	"golang.org/x/crypto/bcrypt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const secretSHA = "{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ="
+
+func writePasswordFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	// The modification time is moved on, as rewrites within the resolution
+	// of the file system would otherwise go unnoticed.
+	later := time.Now().Add(time.Duration(len(content)) * time.Second)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func newTestHandler(t *testing.T, content string) (*BasicAuthHandler, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), ".passwd")
+	writePasswordFile(t, path, content)
+	bah := &BasicAuthHandler{PollInterval: time.Hour}
+	t.Cleanup(bah.Close)
+	return bah, path
+}
+
+// authenticate returns the status of a request with the credentials and the
+// user seen by the handler.
+func authenticate(h http.Handler, user, password string) (int, string) {
+	var principal string
+	r := httptest.NewRequest("GET", "/", nil)
+	if user != "" {
+		r.SetBasicAuth(user, password)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code == http.StatusOK {
+		principal = w.Body.String()
+	}
+	return w.Code, principal
+}
+
+func principalHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if p := identity.PrincipalFrom(r.Context()); p != nil {
+			_, _ = w.Write([]byte(p.User))
+		}
+	})
+}
+
+func TestRestrict(t *testing.T) {
+	bah, path := newTestHandler(t, "alice:"+secretSHA+"\nbob:"+secretSHA+"\n")
+	all := bah.Restrict(path, "", nil)(principalHandler())
+	restricted := bah.Restrict(path, "Admin", []string{"alice"})(principalHandler())
+
+	tests := []struct {
+		name      string
+		handler   http.Handler
+		user      string
+		password  string
+		code      int
+		principal string
+	}{
+		{name: "no credentials", handler: all, code: http.StatusUnauthorized},
+		{name: "unknown user", handler: all, user: "carol", password: "secret", code: http.StatusUnauthorized},
+		{name: "wrong password", handler: all, user: "alice", password: "wrong", code: http.StatusUnauthorized},
+		{name: "any user", handler: all, user: "bob", password: "secret", code: http.StatusOK, principal: "bob"},
+		{name: "listed user", handler: restricted, user: "alice", password: "secret", code: http.StatusOK, principal: "alice"},
+		{name: "user not listed", handler: restricted, user: "bob", password: "secret", code: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		code, principal := authenticate(tt.handler, tt.user, tt.password)
+		if code != tt.code || principal != tt.principal {
+			t.Errorf("%s: status %d, principal %q, want %d, %q", tt.name, code, principal, tt.code, tt.principal)
+		}
+	}
+
+	r := httptest.NewRequest("GET", "/", nil)
+	w := httptest.NewRecorder()
+	restricted.ServeHTTP(w, r)
+	if got, want := w.Header().Get("WWW-Authenticate"), `Basic realm="Admin", charset="UTF-8"`; got != want {
+		t.Errorf("challenge = %s, want %s", got, want)
+	}
+}
+
+func TestUnknownUserChecksDummyHash(t *testing.T) {
+	bah, path := newTestHandler(t, "alice:"+secretSHA+"\n")
+	bah.Cost = bcrypt.MinCost + 1
+	h := bah.BasicAuth(path)(principalHandler())
+
+	if bah.dummy != "" {
+		t.Fatal("dummy hash computed before any login of an unknown user")
+	}
+	if code, _ := authenticate(h, "carol", "secret"); code != http.StatusUnauthorized {
+		t.Fatalf("status %d, want %d", code, http.StatusUnauthorized)
+	}
+	if cost, err := bcrypt.Cost([]byte(bah.dummy)); err != nil || cost != bah.Cost {
+		t.Errorf("dummy hash cost = %d, %v, want %d", cost, err, bah.Cost)
+	}
+	if Verify(bah.dummy, []byte("secret")) {
+		t.Error("dummy hash accepts the password of the unknown user")
+	}
+}
+
+func TestReloadPasswordFile(t *testing.T) {
+	bah, path := newTestHandler(t, "alice:"+secretSHA+"\n")
+	h := bah.BasicAuth(path)(principalHandler())
+
+	if code, _ := authenticate(h, "bob", "secret"); code != http.StatusUnauthorized {
+		t.Fatalf("bob admitted before being added, status %d", code)
+	}
+	writePasswordFile(t, path, "alice:"+secretSHA+"\nbob:"+secretSHA+"\n")
+	bah.reloadIfChanged()
+	if code, _ := authenticate(h, "bob", "secret"); code != http.StatusOK {
+		t.Errorf("bob not admitted after reload, status %d", code)
+	}
+}
+
+func TestDeletePasswordFile(t *testing.T) {
+	bah, path := newTestHandler(t, "alice:"+secretSHA+"\n")
+	h := bah.BasicAuth(path)(principalHandler())
+	if code, _ := authenticate(h, "alice", "secret"); code != http.StatusOK {
+		t.Fatalf("alice not admitted, status %d", code)
+	}
+
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	bah.reloadIfChanged()
+	if bah.Loaded() {
+		t.Error("password file still loaded after deletion")
+	}
+	if code, _ := authenticate(h, "alice", "secret"); code != http.StatusUnauthorized {
+		t.Errorf("alice admitted after deletion, status %d", code)
+	}
+
+	writePasswordFile(t, path, "alice:"+secretSHA+"\n")
+	bah.reloadIfChanged()
+	if code, _ := authenticate(h, "alice", "secret"); code != http.StatusOK {
+		t.Errorf("alice not admitted after the file was restored, status %d", code)
+	}
+}
+
+func TestUpgradeHashes(t *testing.T) {
+	bah, path := newTestHandler(t, "alice:"+secretSHA+" # admin\n")
+	bah.UpgradeHashes = true
+	bah.Cost = 4
+	h := bah.BasicAuth(path)(principalHandler())
+
+	if code, _ := authenticate(h, "alice", "secret"); code != http.StatusOK {
+		t.Fatalf("alice not admitted, status %d", code)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(string(b), " # admin\n") {
+		t.Errorf("comment not kept in %q", b)
+	}
+	entries, err := ParsePasswordFile(strings.NewReader(string(b)), path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if scheme := entries["alice"].Scheme; scheme != SchemeBcrypt {
+		t.Fatalf("scheme after login = %s, want %s", scheme, SchemeBcrypt)
+	}
+	if !Verify(entries["alice"].Hash, []byte("secret")) {
+		t.Error("upgraded hash does not verify the password")
+	}
+	if code, _ := authenticate(h, "alice", "secret"); code != http.StatusOK {
+		t.Errorf("alice not admitted with the upgraded hash, status %d", code)
+	}
+}
+
+func TestThrottledLogin(t *testing.T) {
+	bah, path := newTestHandler(t, "alice:"+secretSHA+"\n")
+	bah.Throttle, _ = newTestThrottle()
+	h := bah.BasicAuth(path)(principalHandler())
+
+	for i := 0; i < 3; i++ {
+		authenticate(h, "alice", "wrong")
+	}
+	r := httptest.NewRequest("GET", "/", nil)
+	r.SetBasicAuth("alice", "secret")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "1" {
+		t.Errorf("status %d, Retry-After %q, want %d, 1", w.Code, w.Header().Get("Retry-After"), http.StatusTooManyRequests)
+	}
+}
+
+func TestHashAndSalt(t *testing.T) {
+	hash := HashAndSalt([]byte("secret"))
+	if cost, err := bcrypt.Cost([]byte(hash)); err != nil || cost != bcrypt.DefaultCost {
+		t.Errorf("cost = %d, %v, want %d", cost, err, bcrypt.DefaultCost)
+	}
+	if !Verify(hash, []byte("secret")) {
+		t.Error("hash does not verify the password")
+	}
+	if _, err := HashAndSaltWithCost([]byte("secret"), bcrypt.MinCost-1); err == nil {
+		t.Error("cost below the minimum accepted")
+	}
+}
+
+func TestWriteError(t *testing.T) {
+	bah, path := newTestHandler(t, "alice:"+secretSHA+"\n")
+	var codes []int
+	bah.WriteError = func(rw http.ResponseWriter, rq *http.Request, code int) {
+		codes = append(codes, code)
+		rw.WriteHeader(code)
+		_, _ = rw.Write([]byte("refused"))
+	}
+	h := bah.BasicAuth(path)(principalHandler())
+
+	r := httptest.NewRequest("GET", "/", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusUnauthorized || w.Body.String() != "refused" || w.Header().Get("WWW-Authenticate") == "" {
+		t.Errorf("status %d, body %q, challenge %q", w.Code, w.Body.String(), w.Header().Get("WWW-Authenticate"))
+	}
+	if len(codes) != 1 || codes[0] != http.StatusUnauthorized {
+		t.Errorf("error written for %v, want [401]", codes)
+	}
+}
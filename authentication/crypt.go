@@ -0,0 +1,405 @@
+package authentication
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"crypto/sha512"
+	"hash"
+	"strconv"
+	"strings"
+)
+
+// The password hashing schemes of crypt(3) found in htpasswd files. They are
+// only used to verify existing hashes; new hashes are always bcrypt.
+
+const itoa64 = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+// encode64 appends n characters encoding the 24 bit value, least
+// significant bits first.
+func encode64(b *strings.Builder, v uint32, n int) {
+	for ; n > 0; n-- {
+		b.WriteByte(itoa64[v&0x3f])
+		v >>= 6
+	}
+}
+
+// md5Crypt computes the MD5 based hash used by Apache ($apr1$) and by
+// crypt(3) ($1$), which only differ in their magic string.
+func md5Crypt(password []byte, magic, hashed string) string {
+	salt := strings.TrimPrefix(hashed, magic)
+	if i := strings.IndexByte(salt, '$'); i >= 0 {
+		salt = salt[:i]
+	}
+	if len(salt) > 8 {
+		salt = salt[:8]
+	}
+
+	d := md5.New()
+	d.Write(password)
+	d.Write([]byte(magic))
+	d.Write([]byte(salt))
+
+	alt := md5.New()
+	alt.Write(password)
+	alt.Write([]byte(salt))
+	alt.Write(password)
+	final := alt.Sum(nil)
+	for i := len(password); i > 0; i -= 16 {
+		d.Write(final[:min(i, 16)])
+	}
+	for i := len(password); i > 0; i >>= 1 {
+		if i&1 != 0 {
+			d.Write([]byte{0})
+		} else {
+			d.Write(password[:1])
+		}
+	}
+	final = d.Sum(nil)
+
+	for i := 0; i < 1000; i++ {
+		round := md5.New()
+		if i&1 != 0 {
+			round.Write(password)
+		} else {
+			round.Write(final)
+		}
+		if i%3 != 0 {
+			round.Write([]byte(salt))
+		}
+		if i%7 != 0 {
+			round.Write(password)
+		}
+		if i&1 != 0 {
+			round.Write(final)
+		} else {
+			round.Write(password)
+		}
+		final = round.Sum(nil)
+	}
+
+	var b strings.Builder
+	b.WriteString(magic + salt + "$")
+	encode64(&b, uint32(final[0])<<16|uint32(final[6])<<8|uint32(final[12]), 4)
+	encode64(&b, uint32(final[1])<<16|uint32(final[7])<<8|uint32(final[13]), 4)
+	encode64(&b, uint32(final[2])<<16|uint32(final[8])<<8|uint32(final[14]), 4)
+	encode64(&b, uint32(final[3])<<16|uint32(final[9])<<8|uint32(final[15]), 4)
+	encode64(&b, uint32(final[4])<<16|uint32(final[10])<<8|uint32(final[5]), 4)
+	encode64(&b, uint32(final[11]), 2)
+	return b.String()
+}
+
+// The byte order in which the SHA-crypt digests are encoded, three bytes
+// per group of four characters.
+var (
+	sha256CryptOrder = [][3]int{
+		{0, 10, 20}, {21, 1, 11}, {12, 22, 2}, {3, 13, 23}, {24, 4, 14},
+		{15, 25, 5}, {6, 16, 26}, {27, 7, 17}, {18, 28, 8}, {9, 19, 29},
+	}
+	sha512CryptOrder = [][3]int{
+		{0, 21, 42}, {22, 43, 1}, {44, 2, 23}, {3, 24, 45}, {25, 46, 4},
+		{47, 5, 26}, {6, 27, 48}, {28, 49, 7}, {50, 8, 29}, {9, 30, 51},
+		{31, 52, 10}, {53, 11, 32}, {12, 33, 54}, {34, 55, 13}, {56, 14, 35},
+		{15, 36, 57}, {37, 58, 16}, {59, 17, 38}, {18, 39, 60}, {40, 61, 19},
+		{62, 20, 41},
+	}
+)
+
+// shaCrypt computes the SHA-256 ($5$) or SHA-512 ($6$) based hash of
+// crypt(3), taking the rounds and the salt from the existing hash.
+func shaCrypt(password []byte, magic, hashed string) string {
+	newHash, order := sha256.New, sha256CryptOrder
+	if magic == "$6$" {
+		newHash, order = sha512.New, sha512CryptOrder
+	}
+
+	setting := strings.TrimPrefix(hashed, magic)
+	rounds, customRounds := 5000, false
+	if r, found := strings.CutPrefix(setting, "rounds="); found {
+		value, rest, _ := strings.Cut(r, "$")
+		if n, err := strconv.Atoi(value); err == nil {
+			rounds, customRounds = min(max(n, 1000), 999999999), true
+			setting = rest
+		}
+	}
+	salt := setting
+	if i := strings.IndexByte(salt, '$'); i >= 0 {
+		salt = salt[:i]
+	}
+	if len(salt) > 16 {
+		salt = salt[:16]
+	}
+
+	sum := func(parts ...[]byte) []byte {
+		h := newHash()
+		for _, p := range parts {
+			h.Write(p)
+		}
+		return h.Sum(nil)
+	}
+	repeat := func(h hash.Hash, b []byte, n int) {
+		for ; n > len(b); n -= len(b) {
+			h.Write(b)
+		}
+		h.Write(b[:n])
+	}
+
+	b := sum(password, []byte(salt), password)
+	a := newHash()
+	a.Write(password)
+	a.Write([]byte(salt))
+	repeat(a, b, len(password))
+	for i := len(password); i > 0; i >>= 1 {
+		if i&1 != 0 {
+			a.Write(b)
+		} else {
+			a.Write(password)
+		}
+	}
+	final := a.Sum(nil)
+
+	dp := newHash()
+	for range password {
+		dp.Write(password)
+	}
+	p := make([]byte, 0, len(password))
+	for digest := dp.Sum(nil); len(p) < len(password); {
+		p = append(p, digest[:min(len(digest), len(password)-len(p))]...)
+	}
+
+	ds := newHash()
+	for i := 0; i < 16+int(final[0]); i++ {
+		ds.Write([]byte(salt))
+	}
+	s := ds.Sum(nil)[:len(salt)]
+
+	for i := 0; i < rounds; i++ {
+		round := newHash()
+		if i&1 != 0 {
+			round.Write(p)
+		} else {
+			round.Write(final)
+		}
+		if i%3 != 0 {
+			round.Write(s)
+		}
+		if i%7 != 0 {
+			round.Write(p)
+		}
+		if i&1 != 0 {
+			round.Write(final)
+		} else {
+			round.Write(p)
+		}
+		final = round.Sum(nil)
+	}
+
+	var out strings.Builder
+	out.WriteString(magic)
+	if customRounds {
+		out.WriteString("rounds=" + strconv.Itoa(rounds) + "$")
+	}
+	out.WriteString(salt + "$")
+	for _, o := range order {
+		encode64(&out, uint32(final[o[0]])<<16|uint32(final[o[1]])<<8|uint32(final[o[2]]), 4)
+	}
+	if magic == "$6$" {
+		encode64(&out, uint32(final[63]), 2)
+	} else {
+		encode64(&out, uint32(final[31])<<8|uint32(final[30]), 3)
+	}
+	return out.String()
+}
+
+// DES tables of the traditional crypt(3), bit positions counted from 1.
+var (
+	desPC1 = [56]byte{
+		57, 49, 41, 33, 25, 17, 9, 1, 58, 50, 42, 34, 26, 18,
+		10, 2, 59, 51, 43, 35, 27, 19, 11, 3, 60, 52, 44, 36,
+		63, 55, 47, 39, 31, 23, 15, 7, 62, 54, 46, 38, 30, 22,
+		14, 6, 61, 53, 45, 37, 29, 21, 13, 5, 28, 20, 12, 4,
+	}
+	desPC2 = [48]byte{
+		14, 17, 11, 24, 1, 5, 3, 28, 15, 6, 21, 10,
+		23, 19, 12, 4, 26, 8, 16, 7, 27, 20, 13, 2,
+		41, 52, 31, 37, 47, 55, 30, 40, 51, 45, 33, 48,
+		44, 49, 39, 56, 34, 53, 46, 42, 50, 36, 29, 32,
+	}
+	desShifts = [16]byte{1, 1, 2, 2, 2, 2, 2, 2, 1, 2, 2, 2, 2, 2, 2, 1}
+	desIP     = [64]byte{
+		58, 50, 42, 34, 26, 18, 10, 2, 60, 52, 44, 36, 28, 20, 12, 4,
+		62, 54, 46, 38, 30, 22, 14, 6, 64, 56, 48, 40, 32, 24, 16, 8,
+		57, 49, 41, 33, 25, 17, 9, 1, 59, 51, 43, 35, 27, 19, 11, 3,
+		61, 53, 45, 37, 29, 21, 13, 5, 63, 55, 47, 39, 31, 23, 15, 7,
+	}
+	desFP = [64]byte{
+		40, 8, 48, 16, 56, 24, 64, 32, 39, 7, 47, 15, 55, 23, 63, 31,
+		38, 6, 46, 14, 54, 22, 62, 30, 37, 5, 45, 13, 53, 21, 61, 29,
+		36, 4, 44, 12, 52, 20, 60, 28, 35, 3, 43, 11, 51, 19, 59, 27,
+		34, 2, 42, 10, 50, 18, 58, 26, 33, 1, 41, 9, 49, 17, 57, 25,
+	}
+	desE = [48]byte{
+		32, 1, 2, 3, 4, 5, 4, 5, 6, 7, 8, 9,
+		8, 9, 10, 11, 12, 13, 12, 13, 14, 15, 16, 17,
+		16, 17, 18, 19, 20, 21, 20, 21, 22, 23, 24, 25,
+		24, 25, 26, 27, 28, 29, 28, 29, 30, 31, 32, 1,
+	}
+	desP = [32]byte{
+		16, 7, 20, 21, 29, 12, 28, 17, 1, 15, 23, 26, 5, 18, 31, 10,
+		2, 8, 24, 14, 32, 27, 3, 9, 19, 13, 30, 6, 22, 11, 4, 25,
+	}
+	desS = [8][64]byte{
+		{
+			14, 4, 13, 1, 2, 15, 11, 8, 3, 10, 6, 12, 5, 9, 0, 7,
+			0, 15, 7, 4, 14, 2, 13, 1, 10, 6, 12, 11, 9, 5, 3, 8,
+			4, 1, 14, 8, 13, 6, 2, 11, 15, 12, 9, 7, 3, 10, 5, 0,
+			15, 12, 8, 2, 4, 9, 1, 7, 5, 11, 3, 14, 10, 0, 6, 13,
+		},
+		{
+			15, 1, 8, 14, 6, 11, 3, 4, 9, 7, 2, 13, 12, 0, 5, 10,
+			3, 13, 4, 7, 15, 2, 8, 14, 12, 0, 1, 10, 6, 9, 11, 5,
+			0, 14, 7, 11, 10, 4, 13, 1, 5, 8, 12, 6, 9, 3, 2, 15,
+			13, 8, 10, 1, 3, 15, 4, 2, 11, 6, 7, 12, 0, 5, 14, 9,
+		},
+		{
+			10, 0, 9, 14, 6, 3, 15, 5, 1, 13, 12, 7, 11, 4, 2, 8,
+			13, 7, 0, 9, 3, 4, 6, 10, 2, 8, 5, 14, 12, 11, 15, 1,
+			13, 6, 4, 9, 8, 15, 3, 0, 11, 1, 2, 12, 5, 10, 14, 7,
+			1, 10, 13, 0, 6, 9, 8, 7, 4, 15, 14, 3, 11, 5, 2, 12,
+		},
+		{
+			7, 13, 14, 3, 0, 6, 9, 10, 1, 2, 8, 5, 11, 12, 4, 15,
+			13, 8, 11, 5, 6, 15, 0, 3, 4, 7, 2, 12, 1, 10, 14, 9,
+			10, 6, 9, 0, 12, 11, 7, 13, 15, 1, 3, 14, 5, 2, 8, 4,
+			3, 15, 0, 6, 10, 1, 13, 8, 9, 4, 5, 11, 12, 7, 2, 14,
+		},
+		{
+			2, 12, 4, 1, 7, 10, 11, 6, 8, 5, 3, 15, 13, 0, 14, 9,
+			14, 11, 2, 12, 4, 7, 13, 1, 5, 0, 15, 10, 3, 9, 8, 6,
+			4, 2, 1, 11, 10, 13, 7, 8, 15, 9, 12, 5, 6, 3, 0, 14,
+			11, 8, 12, 7, 1, 14, 2, 13, 6, 15, 0, 9, 10, 4, 5, 3,
+		},
+		{
+			12, 1, 10, 15, 9, 2, 6, 8, 0, 13, 3, 4, 14, 7, 5, 11,
+			10, 15, 4, 2, 7, 12, 9, 5, 6, 1, 13, 14, 0, 11, 3, 8,
+			9, 14, 15, 5, 2, 8, 12, 3, 7, 0, 4, 10, 1, 13, 11, 6,
+			4, 3, 2, 12, 9, 5, 15, 10, 11, 14, 1, 7, 6, 0, 8, 13,
+		},
+		{
+			4, 11, 2, 14, 15, 0, 8, 13, 3, 12, 9, 7, 5, 10, 6, 1,
+			13, 0, 11, 7, 4, 9, 1, 10, 14, 3, 5, 12, 2, 15, 8, 6,
+			1, 4, 11, 13, 12, 3, 7, 14, 10, 15, 6, 8, 0, 5, 9, 2,
+			6, 11, 13, 8, 1, 4, 10, 7, 9, 5, 0, 15, 14, 2, 3, 12,
+		},
+		{
+			13, 2, 8, 4, 6, 15, 11, 1, 10, 9, 3, 14, 5, 0, 12, 7,
+			1, 15, 13, 8, 10, 3, 7, 4, 12, 5, 6, 11, 0, 14, 9, 2,
+			7, 11, 4, 1, 9, 12, 14, 2, 0, 6, 10, 13, 15, 3, 5, 8,
+			2, 1, 14, 7, 4, 10, 8, 13, 15, 12, 9, 0, 3, 5, 6, 11,
+		},
+	}
+)
+
+// saltValue returns the 6 bit value of a salt character of crypt(3).
+func saltValue(c byte) uint32 {
+	switch {
+	case c >= 'a':
+		return uint32(c-'a') + 38
+	case c >= 'A':
+		return uint32(c-'A') + 12
+	case c >= '.':
+		return uint32(c - '.')
+	}
+	return 0
+}
+
+// desCrypt computes the traditional DES based hash of crypt(3): a zero
+// block is encrypted 25 times with the first eight characters of the
+// password as the key, the salt swapping bits of the expansion.
+func desCrypt(password []byte, hashed string) string {
+	if len(hashed) < 2 {
+		return ""
+	}
+	salt := hashed[:2]
+
+	var key [64]byte
+	for i := 0; i < 8 && i < len(password); i++ {
+		c := password[i] << 1
+		for j := 0; j < 8; j++ {
+			key[i*8+j] = (c >> (7 - j)) & 1
+		}
+	}
+
+	var subkeys [16][48]byte
+	var cd [56]byte
+	for i, p := range desPC1 {
+		cd[i] = key[p-1]
+	}
+	for round := 0; round < 16; round++ {
+		for s := 0; s < int(desShifts[round]); s++ {
+			c0, d0 := cd[0], cd[28]
+			copy(cd[0:27], cd[1:28])
+			copy(cd[28:55], cd[29:56])
+			cd[27], cd[55] = c0, d0
+		}
+		for i, p := range desPC2 {
+			subkeys[round][i] = cd[p-1]
+		}
+	}
+
+	e := desE
+	saltBits := saltValue(salt[0]) | saltValue(salt[1])<<6
+	for i := 0; i < 12; i++ {
+		if saltBits>>i&1 != 0 {
+			e[i], e[i+24] = e[i+24], e[i]
+		}
+	}
+
+	var block [64]byte
+	for n := 0; n < 25; n++ {
+		var lr [64]byte
+		for i, p := range desIP {
+			lr[i] = block[p-1]
+		}
+		for round := 0; round < 16; round++ {
+			var f [32]byte
+			for s := 0; s < 8; s++ {
+				var bits [6]byte
+				for k := 0; k < 6; k++ {
+					bits[k] = lr[32+int(e[s*6+k])-1] ^ subkeys[round][s*6+k]
+				}
+				row := bits[0]<<1 | bits[5]
+				col := bits[1]<<3 | bits[2]<<2 | bits[3]<<1 | bits[4]
+				v := desS[s][row*16+col]
+				for k := 0; k < 4; k++ {
+					f[s*4+k] = (v >> (3 - k)) & 1
+				}
+			}
+			var right [32]byte
+			for i, p := range desP {
+				right[i] = lr[i] ^ f[p-1]
+			}
+			copy(lr[0:32], lr[32:64])
+			copy(lr[32:64], right[:])
+		}
+		var swapped [64]byte
+		copy(swapped[0:32], lr[32:64])
+		copy(swapped[32:64], lr[0:32])
+		for i, p := range desFP {
+			block[i] = swapped[p-1]
+		}
+	}
+
+	var out strings.Builder
+	out.WriteString(salt)
+	for i := 0; i < 66; i += 6 {
+		var v byte
+		for k := 0; k < 6; k++ {
+			v <<= 1
+			if i+k < 64 {
+				v |= block[i+k]
+			}
+		}
+		out.WriteByte(itoa64[v])
+	}
+	return out.String()
+}
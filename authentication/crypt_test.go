@@ -0,0 +1,61 @@
+package authentication
+
+import (
+	"golang.org/x/crypto/bcrypt"
+	"testing"
+)
+
+func TestVerify(t *testing.T) {
+	bcryptHash, err := HashAndSaltWithCost([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		hash   string
+		scheme string
+		weak   bool
+	}{
+		{bcryptHash, SchemeBcrypt, false},
+		{"$apr1$saltsalt$LrttParrLPdxvgutaSXWJ0", SchemeApr1, true},
+		{"$1$saltsalt$9xy1btjgzLYfb7hivXtC//", SchemeMD5Crypt, true},
+		{"$5$saltsalt$0IyaXrmV7.sGNS6tirgqHLqX/G.FBvgkYA.lpPdS5sA", SchemeSHA256Crypt, false},
+		{"$5$rounds=10000$saltsalt$RUsnTSO2Cw.gkRW/RZSmG6BCeuh1a6eDbZfnX4oz1c5", SchemeSHA256Crypt, false},
+		{"$6$saltsalt$TVLlQcbpFVof5W3Yz4DTP6gRstiNuHwwTt6GLc1E5n0U0aDehy0S5knV8wiOQSpT0Y77vwPZN.Pq.H91p5hVO1", SchemeSHA512Crypt, false},
+		{"$6$rounds=1000$saltsalt$LAV5VE5Y7w1d73x1mFNspYWUpazfmwv2SoepNXNKJ/otop/Zok96Hr8Q13LEv0DRY/x8v0/crpIjl8NJSAqXV/", SchemeSHA512Crypt, false},
+		{"{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=", SchemeSHA, true},
+		{"abNANd1rDfiNc", SchemeCrypt, true},
+	}
+	for _, tt := range tests {
+		if scheme := Scheme(tt.hash); scheme != tt.scheme {
+			t.Errorf("Scheme(%s) = %s, want %s", tt.hash, scheme, tt.scheme)
+		}
+		if weak := IsWeak(tt.scheme); weak != tt.weak {
+			t.Errorf("IsWeak(%s) = %v, want %v", tt.scheme, weak, tt.weak)
+		}
+		if !Verify(tt.hash, []byte("secret")) {
+			t.Errorf("%s: password not verified", tt.scheme)
+		}
+		if Verify(tt.hash, []byte("Secret")) {
+			t.Errorf("%s: wrong password verified", tt.scheme)
+		}
+	}
+}
+
+func TestVerifyCryptTruncatesPasswords(t *testing.T) {
+	// crypt only uses the first eight characters of the password.
+	if !Verify("xyAjYtmfRYx/.", []byte("password1")) || !Verify("xyAjYtmfRYx/.", []byte("password2")) {
+		t.Error("crypt hash not verified with the first eight characters")
+	}
+}
+
+func TestVerifyUnsupported(t *testing.T) {
+	for _, hash := range []string{"", "plain", "$9$salt$hash", "{SSHA}abc"} {
+		if scheme := Scheme(hash); scheme != SchemeUnsupported {
+			t.Errorf("Scheme(%q) = %s, want %s", hash, scheme, SchemeUnsupported)
+		}
+		if Verify(hash, []byte(hash)) {
+			t.Errorf("unsupported hash %q verified", hash)
+		}
+	}
+}
@@ -0,0 +1,225 @@
+package authentication
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"golang.org/x/crypto/bcrypt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Password hashing schemes of htpasswd files.
+const (
+	SchemeBcrypt      = "bcrypt"
+	SchemeApr1        = "apr1"
+	SchemeMD5Crypt    = "md5-crypt"
+	SchemeSHA         = "sha"
+	SchemeSHA256Crypt = "sha256-crypt"
+	SchemeSHA512Crypt = "sha512-crypt"
+	SchemeCrypt       = "crypt"
+	SchemeUnsupported = "unsupported"
+)
+
+// fileMutex serialises rewrites of password files within the process.
+var fileMutex sync.Mutex
+
+// Entry is a user of a password file.
+type Entry struct {
+	User   string
+	Hash   string
+	Scheme string
+	Line   int
+}
+
+// Scheme returns the hashing scheme of the hash.
+func Scheme(hash string) string {
+	switch {
+	case strings.HasPrefix(hash, "$2a$"), strings.HasPrefix(hash, "$2b$"), strings.HasPrefix(hash, "$2y$"), strings.HasPrefix(hash, "$2x$"):
+		return SchemeBcrypt
+	case strings.HasPrefix(hash, "$apr1$"):
+		return SchemeApr1
+	case strings.HasPrefix(hash, "$1$"):
+		return SchemeMD5Crypt
+	case strings.HasPrefix(hash, "{SHA}"):
+		return SchemeSHA
+	case strings.HasPrefix(hash, "$5$"):
+		return SchemeSHA256Crypt
+	case strings.HasPrefix(hash, "$6$"):
+		return SchemeSHA512Crypt
+	case len(hash) == 13 && strings.Trim(hash, itoa64) == "":
+		return SchemeCrypt
+	}
+	return SchemeUnsupported
+}
+
+// IsWeak reports whether the scheme is too fast to withstand offline
+// guessing, or limits passwords as crypt does to eight characters.
+func IsWeak(scheme string) bool {
+	switch scheme {
+	case SchemeApr1, SchemeMD5Crypt, SchemeSHA, SchemeCrypt:
+		return true
+	}
+	return false
+}
+
+// Verify reports whether the password matches the hash.
+func Verify(hash string, password []byte) bool {
+	var computed string
+	switch Scheme(hash) {
+	case SchemeBcrypt:
+		return bcrypt.CompareHashAndPassword([]byte(hash), password) == nil
+	case SchemeApr1:
+		computed = md5Crypt(password, "$apr1$", hash)
+	case SchemeMD5Crypt:
+		computed = md5Crypt(password, "$1$", hash)
+	case SchemeSHA:
+		sum := sha1.Sum(password)
+		computed = "{SHA}" + base64.StdEncoding.EncodeToString(sum[:])
+	case SchemeSHA256Crypt:
+		computed = shaCrypt(password, "$5$", hash)
+	case SchemeSHA512Crypt:
+		computed = shaCrypt(password, "$6$", hash)
+	case SchemeCrypt:
+		computed = desCrypt(password, hash)
+	default:
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(computed), []byte(hash)) == 1
+}
+
+// ParsePasswordFile reads the users of a password file in htpasswd format:
+// one user:hash line per user, with blank lines and comments ignored.
+// Comments start with # at the beginning of a line or after whitespace;
+// further fields after the hash are ignored. Malformed lines and unsupported
+// hashes are logged with their line numbers and skipped, and weak hashes are
+// logged as warnings.
+func ParsePasswordFile(r io.Reader, name string) (map[string]Entry, error) {
+	entries := make(map[string]Entry)
+	scanner := bufio.NewScanner(r)
+	for number := 1; scanner.Scan(); number++ {
+		line := stripComment(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		user, rest, found := strings.Cut(line, ":")
+		user = strings.TrimSpace(user)
+		hash, _, _ := strings.Cut(rest, ":")
+		hash = strings.TrimSpace(hash)
+		if !found || user == "" || hash == "" {
+			slog.Warn("malformed line in password file", "file", name, "line", number, "component", "authenticator")
+			continue
+		}
+		if previous, duplicate := entries[user]; duplicate {
+			slog.Warn("duplicate user in password file, the first entry is used", "file", name, "line", number, "user", user, "previous_line", previous.Line, "component", "authenticator")
+			continue
+		}
+
+		entry := Entry{User: user, Hash: hash, Scheme: Scheme(hash), Line: number}
+		switch {
+		case entry.Scheme == SchemeUnsupported:
+			slog.Warn("unsupported password hash in password file", "file", name, "line", number, "user", user, "component", "authenticator")
+			continue
+		case IsWeak(entry.Scheme):
+			slog.Warn("weak password hash in password file, use bcrypt instead", "file", name, "line", number, "user", user, "scheme", entry.Scheme, "component", "authenticator")
+		}
+		entries[user] = entry
+	}
+	return entries, scanner.Err()
+}
+
+// stripComment removes comments and surrounding whitespace from a line.
+func stripComment(line string) string {
+	line = strings.TrimSpace(line)
+	if strings.HasPrefix(line, "#") {
+		return ""
+	}
+	for i := 1; i < len(line); i++ {
+		if line[i] == '#' && (line[i-1] == ' ' || line[i-1] == '\t') {
+			return strings.TrimSpace(line[:i])
+		}
+	}
+	return line
+}
+
+// UpdatePasswordFile sets the hash of the user in the password file, adding
+// the user if missing, or removes the user if the hash is empty. Comments and
+// the other lines are kept; of duplicate entries only the first, which is the
+// one in use, is updated. The file is written to a temporary file which
+// then replaces it, so that it is never left partially written.
+func UpdatePasswordFile(path, user, hash string) error {
+	fileMutex.Lock()
+	defer fileMutex.Unlock()
+
+	var lines []string
+	mode := os.FileMode(0o600)
+	if f, err := os.Open(path); err == nil {
+		if info, err := f.Stat(); err == nil {
+			mode = info.Mode().Perm()
+		}
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			lines = append(lines, scanner.Text())
+		}
+		err = scanner.Err()
+		_ = f.Close()
+		if err != nil {
+			return fmt.Errorf("cannot read password file: %w", err)
+		}
+	} else if !os.IsNotExist(err) {
+		return fmt.Errorf("cannot open password file: %w", err)
+	}
+
+	updated := make([]string, 0, len(lines)+1)
+	found := false
+	for _, line := range lines {
+		content := stripComment(line)
+		name, _, ok := strings.Cut(content, ":")
+		if !ok || strings.TrimSpace(name) != user || (found && hash != "") {
+			updated = append(updated, line)
+			continue
+		}
+		if hash != "" {
+			// A comment following the entry is kept.
+			updated = append(updated, user+":"+hash+strings.TrimSpace(line)[len(content):])
+		}
+		found = true
+	}
+	if !found && hash != "" {
+		updated = append(updated, user+":"+hash)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return fmt.Errorf("cannot create temporary password file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+	for _, line := range updated {
+		_, _ = w.WriteString(line + "\n")
+	}
+	if err = w.Flush(); err == nil {
+		err = tmp.Chmod(mode)
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("cannot write temporary password file: %w", err)
+	}
+	if err = os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("cannot replace password file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+package authentication
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParsePasswordFile(t *testing.T) {
+	const sha = "{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ="
+	tests := []struct {
+		name    string
+		content string
+		want    map[string]Entry
+	}{
+		{name: "empty", content: "", want: map[string]Entry{}},
+		{
+			name:    "entries",
+			content: "alice:" + sha + "\n\n  bob : $1$saltsalt$9xy1btjgzLYfb7hivXtC//  \n",
+			want: map[string]Entry{
+				"alice": {User: "alice", Hash: sha, Scheme: SchemeSHA, Line: 1},
+				"bob":   {User: "bob", Hash: "$1$saltsalt$9xy1btjgzLYfb7hivXtC//", Scheme: SchemeMD5Crypt, Line: 3},
+			},
+		},
+		{
+			name:    "comments",
+			content: "# users\n  # indented\nalice:" + sha + " # admin\nbob:abc#def\n",
+			want: map[string]Entry{
+				"alice": {User: "alice", Hash: sha, Scheme: SchemeSHA, Line: 3},
+			},
+		},
+		{
+			name:    "further fields",
+			content: "alice:" + sha + ":Alice Smith:admin\n",
+			want: map[string]Entry{
+				"alice": {User: "alice", Hash: sha, Scheme: SchemeSHA, Line: 1},
+			},
+		},
+		{
+			name:    "malformed lines",
+			content: "alice\n:" + sha + "\nbob:\ncarol:" + sha + "\n",
+			want: map[string]Entry{
+				"carol": {User: "carol", Hash: sha, Scheme: SchemeSHA, Line: 4},
+			},
+		},
+		{
+			name:    "duplicates",
+			content: "alice:" + sha + "\nalice:abNANd1rDfiNc\n",
+			want: map[string]Entry{
+				"alice": {User: "alice", Hash: sha, Scheme: SchemeSHA, Line: 1},
+			},
+		},
+		{
+			name:    "unsupported hash",
+			content: "alice:plain\nbob:" + sha + "\n",
+			want: map[string]Entry{
+				"bob": {User: "bob", Hash: sha, Scheme: SchemeSHA, Line: 2},
+			},
+		},
+	}
+	for _, tt := range tests {
+		got, err := ParsePasswordFile(strings.NewReader(tt.content), tt.name)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: entries = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestUpdatePasswordFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		user    string
+		hash    string
+		want    string
+	}{
+		{name: "missing file", user: "alice", hash: "new", want: "alice:new\n"},
+		{name: "add", content: "alice:old\n", user: "bob", hash: "new", want: "alice:old\nbob:new\n"},
+		{name: "update", content: "# users\nalice:old\nbob:old\n", user: "alice", hash: "new", want: "# users\nalice:new\nbob:old\n"},
+		{name: "keep comment", content: "alice:old # admin\n", user: "alice", hash: "new", want: "alice:new # admin\n"},
+		{name: "first duplicate", content: "alice:old\nalice:older\n", user: "alice", hash: "new", want: "alice:new\nalice:older\n"},
+		{name: "commented out", content: "#alice:old\n", user: "alice", hash: "new", want: "#alice:old\nalice:new\n"},
+		{name: "remove", content: "alice:old\nbob:old\n", user: "alice", want: "bob:old\n"},
+		{name: "remove duplicates", content: "alice:old\nbob:old\nalice:older\n", user: "alice", want: "bob:old\n"},
+		{name: "remove missing", content: "bob:old\n", user: "alice", want: "bob:old\n"},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(t.TempDir(), ".passwd")
+		if tt.content != "" {
+			if err := os.WriteFile(path, []byte(tt.content), 0o640); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if err := UpdatePasswordFile(path, tt.user, tt.hash); err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		b, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != tt.want {
+			t.Errorf("%s: password file = %q, want %q", tt.name, b, tt.want)
+		}
+
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		mode := os.FileMode(0o640)
+		if tt.content == "" {
+			mode = 0o600
+		}
+		if info.Mode().Perm() != mode {
+			t.Errorf("%s: mode = %v, want %v", tt.name, info.Mode().Perm(), mode)
+		}
+		if entries, _ := os.ReadDir(filepath.Dir(path)); len(entries) != 1 {
+			t.Errorf("%s: %d files left in the directory, want 1", tt.name, len(entries))
+		}
+	}
+}
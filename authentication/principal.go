@@ -0,0 +1,23 @@
+package authentication
+
+import (
+	"context"
+	"github.com/iktech/pepper/identity"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"strings"
+)
+
+// BasicAuthScheme is the scheme of principals authenticated by BasicAuth.
+const BasicAuthScheme = "basic"
+
+// authenticated returns the context carrying the principal, set with
+// identity.WithPrincipal, and adds the principal to the current span.
+func authenticated(ctx context.Context, p *identity.Principal) context.Context {
+	trace.SpanFromContext(ctx).SetAttributes(
+		attribute.String("enduser.id", p.User),
+		attribute.String("enduser.role", strings.Join(p.Roles, ",")),
+		attribute.String("auth.scheme", p.Scheme),
+	)
+	return identity.WithPrincipal(ctx, p)
+}
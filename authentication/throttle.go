@@ -0,0 +1,222 @@
+package authentication
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"log/slog"
+	"net/http"
+	"net/netip"
+	"sync"
+	"time"
+)
+
+// Keys of throttled failure counters.
+const (
+	KeyUser = "user"
+	KeyIP   = "ip"
+)
+
+// maxCounters limits the number of counters of each kind, so that failures
+// from many addresses cannot exhaust memory.
+const maxCounters = 10000
+
+var FailuresTotal = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "http_router_auth_failures_total",
+		Help: "Number of failed authentication attempts",
+	},
+	[]string{"reason"},
+)
+
+var LockoutsTotal = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "http_router_auth_lockouts_total",
+		Help: "Number of times a user or client address has been locked out",
+	},
+	[]string{"key"},
+)
+
+var ThrottledTotal = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "http_router_auth_throttled_total",
+		Help: "Number of authentication attempts rejected while locked out",
+	},
+	[]string{"key"},
+)
+
+// Throttle slows down password guessing by counting failed logins per user
+// and per client address. Once a counter reaches its limit, further attempts
+// are rejected for BaseDelay, doubled with every further failure up to
+// MaxDelay, without checking the password. The counter of a user adds up the
+// failures from all addresses, so that guessing from many addresses is
+// throttled too. Counters are forgotten after Window without failures, and a
+// successful login resets the counter of the user. At most maxCounters
+// counters of each kind are kept, the least recent ones being dropped first.
+// Clients from the allowed addresses are never throttled. A throttle may be
+// shared by several handlers.
+type Throttle struct {
+	MaxFailures      int
+	MaxFailuresPerIP int
+	BaseDelay        time.Duration
+	MaxDelay         time.Duration
+	Window           time.Duration
+	Allow            []netip.Prefix
+	// ClientIP returns the address of the client, the remote address of the
+	// request if nil.
+	ClientIP func(r *http.Request) string
+
+	mu        sync.Mutex
+	users     map[string]*failures
+	ips       map[string]*failures
+	lastSweep time.Time
+	// now returns the current time, time.Now if nil.
+	now func() time.Time
+}
+
+// failures counts the failed logins of a user or client address.
+type failures struct {
+	count int
+	last  time.Time
+	until time.Time
+}
+
+// clientIP returns the address of the client and whether it is allowed.
+func (t *Throttle) clientIP(r *http.Request) (string, bool) {
+	var ip string
+	if t.ClientIP != nil {
+		ip = t.ClientIP(r)
+	} else if addr, err := netip.ParseAddrPort(r.RemoteAddr); err == nil {
+		ip = addr.Addr().String()
+	} else {
+		ip = r.RemoteAddr
+	}
+
+	if addr, err := netip.ParseAddr(ip); err == nil {
+		for _, prefix := range t.Allow {
+			if prefix.Contains(addr.Unmap()) {
+				return ip, true
+			}
+		}
+	}
+	return ip, false
+}
+
+func (t *Throttle) time() time.Time {
+	if t.now != nil {
+		return t.now()
+	}
+	return time.Now()
+}
+
+// locked returns how long attempts for the user from the address are still
+// rejected, and the key of the counter locking them out.
+func (t *Throttle) locked(user, ip string) (time.Duration, string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.time()
+	if f := t.ips[ip]; f != nil && now.Before(f.until) {
+		return f.until.Sub(now), KeyIP
+	}
+	if f := t.users[user]; f != nil && now.Before(f.until) {
+		return f.until.Sub(now), KeyUser
+	}
+	return 0, ""
+}
+
+// failure counts a failed login from the address, and for the user unless
+// the user is unknown, so that guessing user names cannot fill
+// the counters.
+func (t *Throttle) failure(user, ip string, known bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.time()
+	t.sweep(now)
+	if t.ips == nil {
+		t.ips = make(map[string]*failures)
+		t.users = make(map[string]*failures)
+	}
+	if t.count(t.ips, ip, t.MaxFailuresPerIP, now) {
+		LockoutsTotal.WithLabelValues(KeyIP).Inc()
+		slog.Warn("client locked out after failed logins", "event", "auth_lockout", "key", KeyIP, "ip_address", ip, "failures", t.ips[ip].count, "until", t.ips[ip].until, "component", "authenticator")
+	}
+	if known && t.count(t.users, user, t.MaxFailures, now) {
+		LockoutsTotal.WithLabelValues(KeyUser).Inc()
+		slog.Warn("user locked out after failed logins", "event", "auth_lockout", "key", KeyUser, "user", user, "ip_address", ip, "failures", t.users[user].count, "until", t.users[user].until, "component", "authenticator")
+	}
+}
+
+// count adds a failure to the counter of the key and reports whether it
+// locks the key out.
+func (t *Throttle) count(counters map[string]*failures, key string, limit int, now time.Time) bool {
+	f := counters[key]
+	if f == nil || (t.Window > 0 && now.Sub(f.last) > t.Window) {
+		if f == nil && len(counters) >= maxCounters {
+			t.evict(counters, now)
+		}
+		f = &failures{}
+		counters[key] = f
+	}
+	f.count++
+	f.last = now
+	if limit <= 0 || f.count < limit {
+		return false
+	}
+
+	delay := t.BaseDelay
+	for i := limit; i < f.count && delay < t.MaxDelay; i++ {
+		delay *= 2
+	}
+	if t.MaxDelay > 0 && delay > t.MaxDelay {
+		delay = t.MaxDelay
+	}
+	f.until = now.Add(delay)
+	return true
+}
+
+// success resets the counter of the user.
+func (t *Throttle) success(user string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.users, user)
+}
+
+// sweep forgets the counters without failures within the window, at most
+// once per window.
+func (t *Throttle) sweep(now time.Time) {
+	if t.Window <= 0 || now.Sub(t.lastSweep) < t.Window {
+		return
+	}
+	t.lastSweep = now
+	for _, counters := range []map[string]*failures{t.users, t.ips} {
+		for key, f := range counters {
+			if now.Sub(f.last) > t.Window && now.After(f.until) {
+				delete(counters, key)
+			}
+		}
+	}
+}
+
+// evict makes room for a new counter by dropping the expired counters or,
+// if there are none, the one with the least recent failure, preferring
+// counters which do not lock anybody out.
+func (t *Throttle) evict(counters map[string]*failures, now time.Time) {
+	var (
+		oldest    string
+		oldestF   *failures
+		oldestOut bool
+	)
+	for key, f := range counters {
+		if t.Window > 0 && now.Sub(f.last) > t.Window && now.After(f.until) {
+			delete(counters, key)
+			continue
+		}
+		lockedOut := now.Before(f.until)
+		if oldestF == nil || (oldestOut && !lockedOut) || (oldestOut == lockedOut && f.last.Before(oldestF.last)) {
+			oldest, oldestF, oldestOut = key, f, lockedOut
+		}
+	}
+	if len(counters) >= maxCounters && oldestF != nil {
+		delete(counters, oldest)
+	}
+}
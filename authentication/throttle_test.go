@@ -0,0 +1,173 @@
+package authentication
+
+import (
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+// clock is the adjustable time of a throttle under test.
+type clock struct {
+	now time.Time
+}
+
+func (c *clock) advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+func newTestThrottle() (*Throttle, *clock) {
+	c := &clock{now: time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)}
+	t := &Throttle{
+		MaxFailures:      3,
+		MaxFailuresPerIP: 10,
+		BaseDelay:        time.Second,
+		MaxDelay:         10 * time.Second,
+		Window:           time.Minute,
+		now:              func() time.Time { return c.now },
+	}
+	return t, c
+}
+
+func TestThrottleLockout(t *testing.T) {
+	throttle, _ := newTestThrottle()
+	for i := 0; i < 2; i++ {
+		throttle.failure("alice", "192.0.2.1", true)
+	}
+	if wait, _ := throttle.locked("alice", "192.0.2.1"); wait != 0 {
+		t.Fatalf("locked out after 2 failures for %v", wait)
+	}
+
+	throttle.failure("alice", "192.0.2.1", true)
+	if wait, key := throttle.locked("alice", "192.0.2.1"); wait != time.Second || key != KeyUser {
+		t.Errorf("locked() = %v, %s, want 1s, %s", wait, key, KeyUser)
+	}
+	if wait, key := throttle.locked("alice", "192.0.2.2"); wait != time.Second || key != KeyUser {
+		t.Errorf("locked() from another address = %v, %s, want 1s, %s", wait, key, KeyUser)
+	}
+	if wait, _ := throttle.locked("bob", "192.0.2.1"); wait != 0 {
+		t.Errorf("other user locked out for %v", wait)
+	}
+}
+
+func TestThrottleBackoff(t *testing.T) {
+	throttle, c := newTestThrottle()
+	want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 10 * time.Second, 10 * time.Second}
+	for i := 0; i < 2; i++ {
+		throttle.failure("alice", "192.0.2.1", true)
+	}
+	for i, delay := range want {
+		throttle.failure("alice", "192.0.2.1", true)
+		if wait, _ := throttle.locked("alice", "192.0.2.1"); wait != delay {
+			t.Errorf("failure %d: locked out for %v, want %v", i+3, wait, delay)
+		}
+		c.advance(delay)
+		if wait, _ := throttle.locked("alice", "192.0.2.1"); wait != 0 {
+			t.Errorf("failure %d: still locked out for %v after the delay", i+3, wait)
+		}
+	}
+}
+
+func TestThrottleWindow(t *testing.T) {
+	throttle, c := newTestThrottle()
+	for i := 0; i < 2; i++ {
+		throttle.failure("alice", "192.0.2.1", true)
+	}
+	c.advance(time.Minute + time.Second)
+	throttle.failure("alice", "192.0.2.1", true)
+	if wait, _ := throttle.locked("alice", "192.0.2.1"); wait != 0 {
+		t.Errorf("locked out for %v by failures outside the window", wait)
+	}
+	if n := len(throttle.users) + len(throttle.ips); n != 2 {
+		t.Errorf("%d counters after the sweep, want 2", n)
+	}
+}
+
+func TestThrottleIPLimit(t *testing.T) {
+	throttle, _ := newTestThrottle()
+	for i := 0; i < 10; i++ {
+		throttle.failure("user", "192.0.2.1", false)
+	}
+	if wait, key := throttle.locked("alice", "192.0.2.1"); wait != time.Second || key != KeyIP {
+		t.Errorf("locked() = %v, %s, want 1s, %s", wait, key, KeyIP)
+	}
+	if wait, _ := throttle.locked("alice", "192.0.2.2"); wait != 0 {
+		t.Errorf("other address locked out for %v", wait)
+	}
+	if len(throttle.users) != 0 {
+		t.Errorf("failures of unknown users counted per user")
+	}
+}
+
+func TestThrottleUserAcrossAddresses(t *testing.T) {
+	throttle, _ := newTestThrottle()
+	for _, ip := range []string{"192.0.2.1", "198.51.100.1", "203.0.113.1"} {
+		throttle.failure("alice", ip, true)
+	}
+	if wait, key := throttle.locked("alice", "192.0.2.99"); wait != time.Second || key != KeyUser {
+		t.Errorf("locked() = %v, %s, want 1s, %s", wait, key, KeyUser)
+	}
+	if wait, _ := throttle.locked("bob", "192.0.2.1"); wait != 0 {
+		t.Errorf("other user locked out for %v", wait)
+	}
+}
+
+func TestThrottleSuccess(t *testing.T) {
+	throttle, _ := newTestThrottle()
+	for i := 0; i < 2; i++ {
+		throttle.failure("alice", "192.0.2.1", true)
+	}
+	throttle.success("alice")
+	throttle.failure("alice", "192.0.2.1", true)
+	if wait, _ := throttle.locked("alice", "192.0.2.1"); wait != 0 {
+		t.Errorf("locked out for %v after a successful login", wait)
+	}
+}
+
+func TestThrottleCountersAreCapped(t *testing.T) {
+	throttle, c := newTestThrottle()
+	throttle.MaxFailuresPerIP = 2
+	throttle.BaseDelay = time.Minute
+	throttle.MaxDelay = time.Hour
+	throttle.Window = time.Hour
+	throttle.failure("user", "198.51.100.1", false)
+	throttle.failure("user", "198.51.100.1", false)
+
+	for i := 0; i <= maxCounters; i++ {
+		c.advance(time.Millisecond)
+		addr := netip.AddrFrom4([4]byte{10, byte(i >> 16), byte(i >> 8), byte(i)})
+		throttle.failure("user", addr.String(), false)
+	}
+	if n := len(throttle.ips); n != maxCounters {
+		t.Errorf("%d counters, want %d", n, maxCounters)
+	}
+	if _, found := throttle.ips["198.51.100.1"]; !found {
+		t.Error("counter of a locked out address dropped")
+	}
+	if _, found := throttle.ips["10.0.0.0"]; found {
+		t.Error("least recent counter kept")
+	}
+	if _, found := throttle.ips["10.0.39.16"]; !found {
+		t.Error("most recent counter dropped")
+	}
+}
+
+func TestThrottleClientIP(t *testing.T) {
+	throttle := &Throttle{Allow: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}}
+	tests := []struct {
+		remoteAddr string
+		ip         string
+		allowed    bool
+	}{
+		{"192.0.2.1:1234", "192.0.2.1", false},
+		{"10.1.2.3:1234", "10.1.2.3", true},
+		{"[::ffff:10.1.2.3]:1234", "::ffff:10.1.2.3", true},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = tt.remoteAddr
+		if ip, allowed := throttle.clientIP(r); ip != tt.ip || allowed != tt.allowed {
+			t.Errorf("clientIP(%s) = %s, %v, want %s, %v", tt.remoteAddr, ip, allowed, tt.ip, tt.allowed)
+		}
+	}
+}
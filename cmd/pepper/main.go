@@ -0,0 +1,298 @@
+// Command pepper provides administration tools for pepper sites.
+//
+// Usage:
+//
+//	pepper passwd [flags] add|update|delete|verify|list [user]
+//	pepper export [flags] directory
+//
+// The passwd command manages the users of the password file given by
+// http.password.file, read from the configuration file or from the
+// HTTP_PASSWORD_FILE environment variable, unless set with -file. Passwords
+// are read from the terminal without echo, or from the standard input.
+//
+// The export command renders a site whose templates and static files are
+// read from the directories given by http.content.templatesDirectory and
+// http.content.staticDirectory into the directory, so that it can be served
+// by a static file host. Applications embedding their content call
+// pepper.Export after pepper.CreateExport instead.
+package main
+
+import (
+	"bufio"
+	"embed"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/iktech/pepper"
+	"github.com/iktech/pepper/authentication"
+	"github.com/iktech/pepper/controllers"
+	"github.com/spf13/viper"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/term"
+	"io"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+)
+
+const usage = `usage: pepper passwd [flags] add|update|delete|verify|list [user]
+       pepper export [flags] directory
+`
+
+const passwdUsage = `usage: pepper passwd [flags] add|update|delete|verify|list [user]
+
+Commands:
+  add      add a user, failing if it exists
+  update   change the password of an existing user
+  delete   remove a user
+  verify   check the password of a user, exiting with status 1 if wrong
+  list     list the users with their hashing schemes
+
+Flags:
+`
+
+const exportUsage = `usage: pepper export [flags] directory
+
+Renders every route, error page and static file of the site into the
+directory and writes the redirects to a _redirects file.
+
+Flags:
+`
+
+func main() {
+	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, nil)))
+
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	var err error
+	switch os.Args[1] {
+	case "passwd":
+		err = passwd(os.Args[2:], os.Stdin, os.Stdout)
+	case "export":
+		err = export(os.Args[2:])
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "pepper %s: %v\n", os.Args[1], err)
+		os.Exit(1)
+	}
+}
+
+// export renders the site configured by the configuration file into the
+// directory. The content is always read from disk, as this command has no
+// embedded content of its own.
+func export(args []string) error {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), exportUsage)
+		flags.PrintDefaults()
+	}
+	config := flags.String("config", "", "configuration `file` of the site")
+	_ = flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	if *config != "" {
+		viper.SetConfigFile(*config)
+		if err := viper.ReadInConfig(); err != nil {
+			return fmt.Errorf("cannot read configuration: %w", err)
+		}
+	}
+	viper.Set("http.content.useEmbedded", false)
+
+	pepper.CreateExport(embed.FS{}, embed.FS{}, func(routerMap map[string]controllers.Controller) map[string]controllers.Controller {
+		return routerMap
+	})
+	return pepper.Export(flags.Arg(0))
+}
+
+func passwd(args []string, stdin *os.File, stdout io.Writer) error {
+	flags := flag.NewFlagSet("passwd", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), passwdUsage)
+		flags.PrintDefaults()
+	}
+	config := flags.String("config", "", "configuration `file` defining http.password.file and http.password.cost")
+	file := flags.String("file", "", "password `file`, overriding the configuration")
+	cost := flags.Int("cost", 0, "bcrypt `cost` of new hashes, overriding http.password.cost")
+	fromStdin := flags.Bool("stdin", false, "read the password from the standard input even if it is a terminal")
+	_ = flags.Parse(args)
+
+	viper.SetDefault("http.password.file", "/etc/pepper/.passwd")
+	viper.SetDefault("http.password.cost", bcrypt.DefaultCost)
+	_ = viper.BindEnv("http.password.file", "HTTP_PASSWORD_FILE")
+	_ = viper.BindEnv("http.password.cost", "HTTP_PASSWORD_COST")
+	if *config != "" {
+		viper.SetConfigFile(*config)
+		if err := viper.ReadInConfig(); err != nil {
+			return fmt.Errorf("cannot read configuration: %w", err)
+		}
+	}
+	if *file == "" {
+		*file = viper.GetString("http.password.file")
+	}
+	if *cost == 0 {
+		*cost = viper.GetInt("http.password.cost")
+	}
+
+	command, user := flags.Arg(0), flags.Arg(1)
+	if command == "list" {
+		entries, err := readPasswordFile(*file)
+		if err != nil {
+			return err
+		}
+		users := make([]string, 0, len(entries))
+		for u := range entries {
+			users = append(users, u)
+		}
+		sort.Strings(users)
+		for _, u := range users {
+			_, _ = fmt.Fprintf(stdout, "%s\t%s\n", u, entries[u].Scheme)
+		}
+		return nil
+	}
+
+	if user == "" || flags.NArg() != 2 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	if strings.ContainsAny(user, ":# \t") {
+		return fmt.Errorf("user name %q must not contain colons, number signs or whitespace", user)
+	}
+
+	entries, err := readPasswordFile(*file)
+	if err != nil && !(errors.Is(err, os.ErrNotExist) && command == "add") {
+		return err
+	}
+	entry, exists := entries[user]
+	reader := newPasswordReader(stdin, *fromStdin)
+
+	switch command {
+	case "add", "update":
+		if command == "add" && exists {
+			return fmt.Errorf("user %s exists, use update to change the password", user)
+		}
+		if command == "update" && !exists {
+			return fmt.Errorf("user %s does not exist, use add to create it", user)
+		}
+		password, err := reader.read("Password: ", true)
+		if err != nil {
+			return err
+		}
+		hash, err := authentication.HashAndSaltWithCost(password, *cost)
+		if err != nil {
+			return err
+		}
+		if err := authentication.UpdatePasswordFile(*file, user, hash); err != nil {
+			return err
+		}
+		action := "added"
+		if exists {
+			action = "updated"
+		}
+		_, _ = fmt.Fprintf(stdout, "%s user %s in %s\n", action, user, *file)
+	case "delete":
+		if !exists {
+			return fmt.Errorf("user %s does not exist", user)
+		}
+		if err := authentication.UpdatePasswordFile(*file, user, ""); err != nil {
+			return err
+		}
+		_, _ = fmt.Fprintf(stdout, "deleted user %s from %s\n", user, *file)
+	case "verify":
+		if !exists {
+			return fmt.Errorf("user %s does not exist", user)
+		}
+		password, err := reader.read("Password: ", false)
+		if err != nil {
+			return err
+		}
+		if !authentication.Verify(entry.Hash, password) {
+			return fmt.Errorf("wrong password for user %s", user)
+		}
+		_, _ = fmt.Fprintf(stdout, "password of user %s is correct (%s)\n", user, entry.Scheme)
+		if authentication.IsWeak(entry.Scheme) {
+			_, _ = fmt.Fprintf(stdout, "the %s hash is weak, run pepper passwd update %s to replace it with bcrypt\n", entry.Scheme, user)
+		}
+	default:
+		flags.Usage()
+		os.Exit(2)
+	}
+	return nil
+}
+
+func readPasswordFile(name string) (map[string]authentication.Entry, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open password file: %w", err)
+	}
+	defer f.Close()
+	return authentication.ParsePasswordFile(f, name)
+}
+
+// passwordReader reads passwords from the terminal without echo, or line by
+// line from the standard input if it is not a terminal.
+type passwordReader struct {
+	stdin    *os.File
+	terminal bool
+	lines    *bufio.Reader
+}
+
+func newPasswordReader(stdin *os.File, fromStdin bool) *passwordReader {
+	return &passwordReader{
+		stdin:    stdin,
+		terminal: !fromStdin && term.IsTerminal(int(stdin.Fd())),
+		lines:    bufio.NewReader(stdin),
+	}
+}
+
+// read reads a password, asking for it twice on a terminal if confirm is
+// set.
+func (p *passwordReader) read(prompt string, confirm bool) ([]byte, error) {
+	if !p.terminal {
+		line, err := p.lines.ReadString('\n')
+		if err != nil && (err != io.EOF || line == "") {
+			return nil, fmt.Errorf("cannot read password: %w", err)
+		}
+		password := strings.TrimRight(line, "\r\n")
+		if password == "" {
+			return nil, errors.New("password must not be empty")
+		}
+		return []byte(password), nil
+	}
+
+	password, err := p.prompt(prompt)
+	if err != nil {
+		return nil, err
+	}
+	if len(password) == 0 {
+		return nil, errors.New("password must not be empty")
+	}
+	if confirm {
+		again, err := p.prompt("Repeat password: ")
+		if err != nil {
+			return nil, err
+		}
+		if string(again) != string(password) {
+			return nil, errors.New("passwords do not match")
+		}
+	}
+	return password, nil
+}
+
+func (p *passwordReader) prompt(prompt string) ([]byte, error) {
+	fmt.Fprint(os.Stderr, prompt)
+	password, err := term.ReadPassword(int(p.stdin.Fd()))
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read password: %w", err)
+	}
+	return password, nil
+}
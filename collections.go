@@ -0,0 +1,78 @@
+package pepper
+
+import (
+	"github.com/iktech/pepper/collections"
+	"github.com/iktech/pepper/controllers"
+	"github.com/iktech/pepper/model"
+	"github.com/spf13/viper"
+	"io/fs"
+	"log/slog"
+	"os"
+	"time"
+)
+
+// loadCollections reads the collections defined under http.collections and
+// adds the controllers of their pages to the router map. Routes defined
+// explicitly in http.controllers take precedence. Feeds leave out the entries
+// which are protected.
+func loadCollections(fsRoot fs.FS, includes []string, routerMap map[string]controllers.Controller, protected func(string) bool) {
+	for name := range viper.GetStringMap("http.collections") {
+		key := "http.collections." + name
+		viper.SetDefault(key+".directory", name)
+		viper.SetDefault(key+".path", name)
+		viper.SetDefault(key+".drafts", Debug)
+		viper.SetDefault(key+".feed.maxAge", time.Hour)
+
+		def := collections.Definition{
+			Name:            name,
+			Directory:       viper.GetString(key + ".directory"),
+			Path:            viper.GetString(key + ".path"),
+			PageSize:        viper.GetInt(key + ".pageSize"),
+			IndexTemplate:   viper.GetString(key + ".templates.index"),
+			EntryTemplate:   viper.GetString(key + ".templates.entry"),
+			TagTemplate:     viper.GetString(key + ".templates.tag"),
+			ArchiveTemplate: viper.GetString(key + ".templates.archive"),
+			Drafts:          viper.GetBool(key + ".drafts"),
+			Feed: collections.FeedDefinition{
+				RSS:         viper.GetString(key + ".feed.rss"),
+				Atom:        viper.GetString(key + ".feed.atom"),
+				Title:       viper.GetString(key + ".feed.title"),
+				Description: viper.GetString(key + ".feed.description"),
+				Items:       viper.GetInt(key + ".feed.items"),
+				Full:        viper.GetString(key+".feed.content") == "full",
+				MaxAge:      viper.GetDuration(key + ".feed.maxAge"),
+				Template:    viper.GetString(key + ".feed.template"),
+			},
+		}
+
+		c, err := collections.Load(def, fsRoot)
+		if err != nil {
+			slog.Error("cannot load collection", "collection", name, KeyError, err, KeyComponent, ComponentService)
+			os.Exit(1)
+		}
+
+		base := model.Model{
+			TemplatesDirectory: fsRoot,
+			Includes:           includes,
+			GoogleAnalyticsId:  GoogleAnayticsId,
+		}
+		routes := c.Controllers(base)
+		if siteURLKnown() {
+			for path, controller := range c.Feeds(base, SiteURL, protected) {
+				routes[path] = controller
+			}
+		} else if def.Feed.RSS != "" || def.Feed.Atom != "" {
+			slog.Warn("feeds are not served as http.site.baseUrl is not set", "collection", name, KeyComponent, ComponentService)
+		}
+		for path, controller := range routes {
+			if _, found := routerMap[path]; found {
+				slog.Warn("collection page is shadowed by a controller", "collection", name, "path", path, KeyComponent, ComponentService)
+				continue
+			}
+			if p, ok := controller.(collections.Page); ok && p.Model.Robots == "" {
+				p.Model.Robots = robotsFor(path)
+			}
+			routerMap[path] = controller
+		}
+	}
+}
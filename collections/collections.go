@@ -0,0 +1,477 @@
+package collections
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"github.com/iktech/pepper/controllers"
+	"github.com/iktech/pepper/model"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+const (
+	KeyError             = "error"
+	KeyComponent         = "component"
+	ComponentCollections = "collections"
+	DefaultPageSize      = 10
+)
+
+var (
+	registry = make(map[string]*Collection)
+	mutex    sync.RWMutex
+)
+
+func init() {
+	model.Functions["collection"] = Get
+}
+
+// Definition describes where the entries of a collection are read from and
+// how its pages are rendered.
+type Definition struct {
+	Name            string
+	Directory       string
+	Path            string
+	PageSize        int
+	IndexTemplate   string
+	EntryTemplate   string
+	TagTemplate     string
+	ArchiveTemplate string
+	Drafts          bool
+	Feed            FeedDefinition
+}
+
+// Collection is a set of dated entries, newest first, together with the
+// tag and archive groupings derived from them.
+type Collection struct {
+	Name       string
+	Path       string
+	PageSize   int
+	Entries    []*Entry
+	Tags       []*Tag
+	Archives   []*Archive
+	Definition Definition
+	tags       map[string]*Tag
+	slugs      map[string]*Tag
+	loaded     time.Time
+}
+
+// Tag groups the entries of a collection sharing the same tag.
+type Tag struct {
+	Name    string
+	Slug    string
+	Path    string
+	Entries []*Entry
+}
+
+// Archive groups the entries of a collection published in the same month.
+type Archive struct {
+	Year    int
+	Month   time.Month
+	Path    string
+	Entries []*Entry
+}
+
+// Pagination describes the position of a listing page.
+type Pagination struct {
+	Page       int
+	TotalPages int
+	Previous   string
+	Next       string
+}
+
+// Page is the controller rendering a single page of a collection. It is also
+// the data passed to the template.
+type Page struct {
+	*model.Model
+	Collection *Collection
+	Entry      *Entry
+	Entries    []*Entry
+	Tag        *Tag
+	Archive    *Archive
+	Pagination Pagination
+}
+
+// Get returns the loaded collection with the given name, or nil. It is
+// available to templates as the "collection" function.
+func Get(name string) *Collection {
+	mutex.RLock()
+	defer mutex.RUnlock()
+	return registry[name]
+}
+
+// Load reads the entries of the collection from the file system and
+// registers the collection under its name.
+func Load(def Definition, fsys fs.FS) (*Collection, error) {
+	if def.PageSize <= 0 {
+		def.PageSize = DefaultPageSize
+	}
+	def.Path = strings.Trim(def.Path, "/")
+
+	c := &Collection{
+		Name:       def.Name,
+		Path:       def.Path,
+		PageSize:   def.PageSize,
+		Definition: def,
+		tags:       make(map[string]*Tag),
+		slugs:      make(map[string]*Tag),
+		loaded:     time.Now(),
+	}
+
+	err := fs.WalkDir(fsys, def.Directory, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !isMarkdown(name) {
+			return nil
+		}
+
+		entry, tags, err := readEntry(fsys, name)
+		if err != nil {
+			return err
+		}
+		if entry.Draft && !def.Drafts {
+			slog.Debug("skipping draft entry", "file", name, KeyComponent, ComponentCollections)
+			return nil
+		}
+		if entry.Date.IsZero() && entry.ModTime.IsZero() {
+			slog.Warn("collection entry has no date", "file", name, KeyComponent, ComponentCollections)
+		}
+
+		entry.Path = join(c.Path, entry.Slug)
+		for _, t := range tags {
+			entry.Tags = append(entry.Tags, c.tag(t))
+		}
+		c.Entries = append(c.Entries, entry)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("cannot load collection %s: %w", def.Name, err)
+	}
+
+	sort.SliceStable(c.Entries, func(i, j int) bool {
+		a, b := c.Entries[i], c.Entries[j]
+		if a.LastPublished().Equal(b.LastPublished()) {
+			return a.Slug < b.Slug
+		}
+		return a.LastPublished().After(b.LastPublished())
+	})
+
+	seen := make(map[string]string)
+	archives := make(map[string]*Archive)
+	for i, e := range c.Entries {
+		if other, found := seen[e.Slug]; found {
+			return nil, fmt.Errorf("cannot load collection %s: entries %s and %s share the slug %s", def.Name, other, e.Source, e.Slug)
+		}
+		seen[e.Slug] = e.Source
+
+		if i > 0 {
+			e.Next = c.Entries[i-1]
+		}
+		if i < len(c.Entries)-1 {
+			e.Previous = c.Entries[i+1]
+		}
+
+		for _, t := range e.Tags {
+			t.Entries = append(t.Entries, e)
+		}
+
+		date := e.LastPublished()
+		if date.IsZero() {
+			continue
+		}
+		key := date.Format("2006/01")
+		a := archives[key]
+		if a == nil {
+			a = &Archive{Year: date.Year(), Month: date.Month(), Path: join(c.Path, key)}
+			archives[key] = a
+			c.Archives = append(c.Archives, a)
+		}
+		a.Entries = append(a.Entries, e)
+	}
+
+	for _, t := range c.tags {
+		c.Tags = append(c.Tags, t)
+	}
+	sort.Slice(c.Tags, func(i, j int) bool {
+		return c.Tags[i].Slug < c.Tags[j].Slug
+	})
+
+	mutex.Lock()
+	registry[def.Name] = c
+	mutex.Unlock()
+
+	slog.Info("loaded collection", "collection", def.Name, "entries", len(c.Entries), KeyComponent, ComponentCollections)
+	return c, nil
+}
+
+// Recent returns at most n newest entries of the collection.
+func (c *Collection) Recent(n int) []*Entry {
+	if n < 0 || n > len(c.Entries) {
+		n = len(c.Entries)
+	}
+	return c.Entries[:n]
+}
+
+// Tagged returns the entries of the collection tagged with the given name.
+func (c *Collection) Tagged(name string) []*Entry {
+	if t := c.tags[tagKey(name)]; t != nil {
+		return t.Entries
+	}
+	return nil
+}
+
+// Entry returns the entry with the given slug, or nil.
+func (c *Collection) Entry(slug string) *Entry {
+	for _, e := range c.Entries {
+		if e.Slug == slug {
+			return e
+		}
+	}
+	return nil
+}
+
+// URL returns the absolute path of the collection index.
+func (c *Collection) URL() string {
+	return "/" + c.Path
+}
+
+// Controllers returns the controllers for all pages of the collection keyed
+// by their path, in the same form as the router map of the service. The base
+// model provides the templates directory, includes and analytics settings.
+func (c *Collection) Controllers(base model.Model) map[string]controllers.Controller {
+	routes := make(map[string]controllers.Controller)
+	def := c.Definition
+
+	tagTemplate := def.TagTemplate
+	if tagTemplate == "" {
+		tagTemplate = def.IndexTemplate
+	}
+	archiveTemplate := def.ArchiveTemplate
+	if archiveTemplate == "" {
+		archiveTemplate = def.IndexTemplate
+	}
+
+	if def.IndexTemplate != "" {
+		c.paginate(routes, base, def.IndexTemplate, c.Path, c.Entries, func(p *Page) {})
+	}
+
+	if tagTemplate != "" {
+		for _, t := range c.Tags {
+			tag := t
+			c.paginate(routes, base, tagTemplate, tag.Path, tag.Entries, func(p *Page) {
+				p.Tag = tag
+			})
+		}
+	}
+
+	if archiveTemplate != "" {
+		years := make(map[int]*Archive)
+		var order []*Archive
+		for _, a := range c.Archives {
+			month := a
+			c.paginate(routes, base, archiveTemplate, month.Path, month.Entries, func(p *Page) {
+				p.Archive = month
+			})
+
+			year := years[a.Year]
+			if year == nil {
+				year = &Archive{Year: a.Year, Path: join(c.Path, strconv.Itoa(a.Year))}
+				years[a.Year] = year
+				order = append(order, year)
+			}
+			year.Entries = append(year.Entries, a.Entries...)
+		}
+		for _, a := range order {
+			year := a
+			c.paginate(routes, base, archiveTemplate, year.Path, year.Entries, func(p *Page) {
+				p.Archive = year
+			})
+		}
+	}
+
+	if def.EntryTemplate != "" {
+		for _, e := range c.Entries {
+			m := base
+			m.Path = e.Path
+			m.Template = def.EntryTemplate
+			m.Robots = e.Robots()
+			routes[e.Path] = Page{
+				Model:      &m,
+				Collection: c,
+				Entry:      e,
+			}
+		}
+	}
+
+	return routes
+}
+
+// Handle renders the page using its template.
+func (p Page) Handle(r *http.Request) (int, string, string, *bytes.Buffer, *model.ProcessingError) {
+	return p.RenderRequest(controllers.Debug, r, p)
+}
+
+// LastModified returns the modification time of the entry, or of the newest
+// entry listed on the page.
+func (p Page) LastModified() time.Time {
+	if p.Entry != nil {
+		return p.Entry.LastModified()
+	}
+
+	var latest time.Time
+	for _, e := range p.Entries {
+		if t := e.LastModified(); t.After(latest) {
+			latest = t
+		}
+	}
+	return latest
+}
+
+// SitemapOptions returns the sitemap settings given in the "sitemap" section
+// of the entry front matter.
+func (p Page) SitemapOptions() controllers.SitemapOptions {
+	var options controllers.SitemapOptions
+	if p.Entry == nil {
+		return options
+	}
+
+	settings, ok := p.Entry.Params["sitemap"].(map[string]interface{})
+	if !ok {
+		return options
+	}
+	switch v := settings["priority"].(type) {
+	case float64:
+		options.Priority = v
+	case int:
+		options.Priority = float64(v)
+	}
+	options.ChangeFreq, _ = settings["changefreq"].(string)
+	options.Exclude, _ = settings["exclude"].(bool)
+	return options
+}
+
+func (c *Collection) paginate(routes map[string]controllers.Controller, base model.Model, template string, root string, entries []*Entry, customise func(p *Page)) {
+	pages := (len(entries) + c.PageSize - 1) / c.PageSize
+	if pages == 0 {
+		pages = 1
+	}
+
+	for i := 1; i <= pages; i++ {
+		from := (i - 1) * c.PageSize
+		to := from + c.PageSize
+		if to > len(entries) {
+			to = len(entries)
+		}
+
+		m := base
+		m.Path = pagePath(root, i)
+		m.Template = template
+		p := Page{
+			Model:      &m,
+			Collection: c,
+			Entries:    entries[from:to],
+			Pagination: Pagination{
+				Page:       i,
+				TotalPages: pages,
+			},
+		}
+		if i > 1 {
+			p.Pagination.Previous = "/" + pagePath(root, i-1)
+		}
+		if i < pages {
+			p.Pagination.Next = "/" + pagePath(root, i+1)
+		}
+		customise(&p)
+		routes[m.Path] = p
+	}
+}
+
+// tag returns the tag with the given name, creating it on first use. Names
+// differing only in case are the same tag. Different names mapping to the
+// same slug, such as "C" and "C++", get a numeric suffix in the order they
+// are first seen.
+func (c *Collection) tag(name string) *Tag {
+	key := tagKey(name)
+	t := c.tags[key]
+	if t != nil {
+		return t
+	}
+
+	slug := slugify(name)
+	if other := c.slugs[slug]; other != nil {
+		candidate := slug
+		for n := 2; c.slugs[candidate] != nil; n++ {
+			candidate = slug + "-" + strconv.Itoa(n)
+		}
+		slog.Warn("tags share a slug", "collection", c.Name, "tag", name, "other", other.Name, "slug", candidate, KeyComponent, ComponentCollections)
+		slug = candidate
+	}
+
+	t = &Tag{Name: name, Slug: slug, Path: join(c.Path, "tags", slug)}
+	c.tags[key] = t
+	c.slugs[slug] = t
+	return t
+}
+
+// URL returns the absolute path of the tag listing.
+func (t *Tag) URL() string {
+	return "/" + t.Path
+}
+
+// URL returns the absolute path of the archive listing.
+func (a *Archive) URL() string {
+	return "/" + a.Path
+}
+
+func pagePath(root string, page int) string {
+	if page == 1 {
+		return root
+	}
+	return join(root, "page", strconv.Itoa(page))
+}
+
+func join(elements ...string) string {
+	return strings.Trim(path.Join(elements...), "/")
+}
+
+func tagKey(name string) string {
+	return strings.ToLower(strings.TrimSpace(name))
+}
+
+// slugify turns a name into a single path segment of lower case letters,
+// digits and hyphens. Runs of other characters, including those with a
+// meaning in URLs such as "/" or "?", become a single hyphen. Names without
+// any letter or digit are hex encoded.
+func slugify(name string) string {
+	var b strings.Builder
+	hyphen := false
+	for _, c := range strings.ToLower(name) {
+		if unicode.IsLetter(c) || unicode.IsDigit(c) {
+			if hyphen && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(c)
+			hyphen = false
+		} else {
+			hyphen = true
+		}
+	}
+	if b.Len() == 0 && name != "" {
+		return hex.EncodeToString([]byte(name))
+	}
+	return b.String()
+}
+
+func isMarkdown(name string) bool {
+	ext := strings.ToLower(path.Ext(name))
+	return ext == ".md" || ext == ".markdown"
+}
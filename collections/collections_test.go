@@ -0,0 +1,179 @@
+package collections
+
+import (
+	"github.com/iktech/pepper/model"
+	"testing"
+	"testing/fstest"
+)
+
+func entryFile(tags string) *fstest.MapFile {
+	return &fstest.MapFile{Data: []byte("---\ntags: [" + tags + "]\n---\nBody.\n")}
+}
+
+func loadTestCollection(t *testing.T, pageSize int) *Collection {
+	fsys := fstest.MapFS{
+		"posts/2026-01-10-first.md":  entryFile("Go, C"),
+		"posts/2026-02-05-second.md": entryFile("go, C++"),
+		"posts/2026-02-20-third.md":  entryFile("GO"),
+		"posts/2025-12-31-older.md":  entryFile("c 2"),
+		"posts/notes.txt":            &fstest.MapFile{Data: []byte("not an entry")},
+	}
+	c, err := Load(Definition{Name: t.Name(), Directory: "posts", Path: "/blog/", PageSize: pageSize, IndexTemplate: "index.html", EntryTemplate: "entry.html"}, fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func slugs(entries []*Entry) []string {
+	var s []string
+	for _, e := range entries {
+		s = append(s, e.Slug)
+	}
+	return s
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestLoadSortsEntries(t *testing.T) {
+	c := loadTestCollection(t, 10)
+
+	if got, want := slugs(c.Entries), []string{"third", "second", "first", "older"}; !equal(got, want) {
+		t.Fatalf("entries = %v, want %v", got, want)
+	}
+	if c.Entries[0].Next != nil || c.Entries[0].Previous != c.Entries[1] {
+		t.Error("newest entry not linked to the one before it")
+	}
+	if c.Entries[3].Next != c.Entries[2] || c.Entries[3].Previous != nil {
+		t.Error("oldest entry not linked to the one after it")
+	}
+	if got := c.Entries[0].Path; got != "blog/third" {
+		t.Errorf("entry path = %q, want blog/third", got)
+	}
+}
+
+func TestLoadGroupsEntries(t *testing.T) {
+	c := loadTestCollection(t, 10)
+
+	tags := make(map[string]*Tag)
+	for _, tag := range c.Tags {
+		tags[tag.Slug] = tag
+	}
+	tests := []struct {
+		slug    string
+		name    string
+		entries []string
+	}{
+		{"go", "Go", []string{"third", "second", "first"}},
+		{"c", "C", []string{"first"}},
+		{"c-2", "c 2", []string{"older"}},
+		{"c-3", "C++", []string{"second"}},
+	}
+	if len(c.Tags) != len(tests) {
+		t.Errorf("%d tags, want %d", len(c.Tags), len(tests))
+	}
+	for _, tt := range tests {
+		tag := tags[tt.slug]
+		if tag == nil {
+			t.Errorf("no tag with the slug %s", tt.slug)
+			continue
+		}
+		if tag.Name != tt.name || tag.Path != "blog/tags/"+tt.slug {
+			t.Errorf("tag %s = %q at %s, want %q at blog/tags/%s", tt.slug, tag.Name, tag.Path, tt.name, tt.slug)
+		}
+		if got := slugs(tag.Entries); !equal(got, tt.entries) {
+			t.Errorf("tag %s entries = %v, want %v", tt.slug, got, tt.entries)
+		}
+	}
+	if got := slugs(c.Tagged("go")); !equal(got, []string{"third", "second", "first"}) {
+		t.Errorf("Tagged(go) = %v", got)
+	}
+	if got := slugs(c.Tagged("C++")); !equal(got, []string{"second"}) {
+		t.Errorf("Tagged(C++) = %v", got)
+	}
+
+	var archives []string
+	for _, a := range c.Archives {
+		archives = append(archives, a.Path)
+	}
+	if want := []string{"blog/2026/02", "blog/2026/01", "blog/2025/12"}; !equal(archives, want) {
+		t.Errorf("archives = %v, want %v", archives, want)
+	}
+	if got := slugs(c.Archives[0].Entries); !equal(got, []string{"third", "second"}) {
+		t.Errorf("archive 2026/02 entries = %v", got)
+	}
+}
+
+func TestControllersPaginate(t *testing.T) {
+	c := loadTestCollection(t, 3)
+	routes := c.Controllers(model.Model{})
+
+	tests := []struct {
+		path     string
+		entries  []string
+		previous string
+		next     string
+	}{
+		{"blog", []string{"third", "second", "first"}, "", "/blog/page/2"},
+		{"blog/page/2", []string{"older"}, "/blog", ""},
+		{"blog/tags/go", []string{"third", "second", "first"}, "", ""},
+		{"blog/2026", []string{"third", "second", "first"}, "", ""},
+		{"blog/2026/02", []string{"third", "second"}, "", ""},
+	}
+	for _, tt := range tests {
+		p, ok := routes[tt.path].(Page)
+		if !ok {
+			t.Errorf("no page at %s", tt.path)
+			continue
+		}
+		if got := slugs(p.Entries); !equal(got, tt.entries) {
+			t.Errorf("%s entries = %v, want %v", tt.path, got, tt.entries)
+		}
+		if p.Pagination.Previous != tt.previous || p.Pagination.Next != tt.next {
+			t.Errorf("%s previous, next = %q, %q, want %q, %q", tt.path, p.Pagination.Previous, p.Pagination.Next, tt.previous, tt.next)
+		}
+	}
+	if _, ok := routes["blog/page/3"]; ok {
+		t.Error("page beyond the last one")
+	}
+	if p, ok := routes["blog/older"].(Page); !ok || p.Entry == nil || p.Template != "entry.html" {
+		t.Error("no entry page at blog/older")
+	}
+}
+
+func TestSlugify(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"Go", "go"},
+		{"Release Notes", "release-notes"},
+		{"  spaced   out  ", "spaced-out"},
+		{"ci/cd", "ci-cd"},
+		{"what?", "what"},
+		{"C# and F#", "c-and-f"},
+		{"a&b=c", "a-b-c"},
+		{"already-slugged", "already-slugged"},
+		{"--leading and trailing--", "leading-and-trailing"},
+		{"Café Crème", "café-crème"},
+		{"2024", "2024"},
+		{"../etc", "etc"},
+		{"#?/", "233f2f"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := slugify(tt.name); got != tt.want {
+			t.Errorf("slugify(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,217 @@
+package collections
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/renderer/html"
+	"gopkg.in/yaml.v3"
+	"html/template"
+	"io/fs"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// moreSeparator marks the end of the entry summary in the Markdown source.
+const moreSeparator = "<!--more-->"
+
+var (
+	markdown = goldmark.New(
+		goldmark.WithExtensions(extension.GFM),
+		goldmark.WithRendererOptions(html.WithUnsafe()),
+	)
+	datedFileName = regexp.MustCompile(`^(\d{4}-\d{2}-\d{2})-(.+)$`)
+)
+
+// Entry is a single dated document of a collection.
+type Entry struct {
+	Title    string
+	Slug     string
+	Path     string
+	Date     time.Time
+	Updated  time.Time
+	Tags     []*Tag
+	Summary  template.HTML
+	Content  template.HTML
+	Params   map[string]interface{}
+	Draft    bool
+	Source   string
+	ModTime  time.Time
+	Previous *Entry
+	Next     *Entry
+}
+
+type frontMatter struct {
+	Title   string    `yaml:"title"`
+	Slug    string    `yaml:"slug"`
+	Date    time.Time `yaml:"date"`
+	Updated time.Time `yaml:"updated"`
+	Tags    []string  `yaml:"tags"`
+	Summary string    `yaml:"summary"`
+	Draft   bool      `yaml:"draft"`
+}
+
+// URL returns the absolute path of the entry page.
+func (e *Entry) URL() string {
+	return "/" + e.Path
+}
+
+// LastModified returns the time the entry was last changed, preferring the
+// dates given in the front matter over the file modification time.
+func (e *Entry) LastModified() time.Time {
+	if !e.Updated.IsZero() {
+		return e.Updated
+	}
+	if !e.Date.IsZero() {
+		return e.Date
+	}
+	return e.ModTime
+}
+
+// LastPublished returns the publication date of the entry, falling back to
+// the file modification time.
+func (e *Entry) LastPublished() time.Time {
+	if !e.Date.IsZero() {
+		return e.Date
+	}
+	return e.ModTime
+}
+
+// Robots returns the robots directives given in the front matter, either as
+// a "robots" string or as a "noindex" flag.
+func (e *Entry) Robots() string {
+	if robots, ok := e.Params["robots"].(string); ok {
+		return robots
+	}
+	if noindex, _ := e.Params["noindex"].(bool); noindex {
+		return "noindex"
+	}
+	return ""
+}
+
+// HasTag reports whether the entry is tagged with the given tag name.
+func (e *Entry) HasTag(name string) bool {
+	for _, t := range e.Tags {
+		if strings.EqualFold(t.Name, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func readEntry(fsys fs.FS, name string) (*Entry, []string, error) {
+	b, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var modTime time.Time
+	if info, err := fs.Stat(fsys, name); err == nil {
+		modTime = info.ModTime()
+	}
+
+	header, body := splitFrontMatter(b)
+	var fm frontMatter
+	params := make(map[string]interface{})
+	if header != nil {
+		if err = yaml.Unmarshal(header, &fm); err != nil {
+			return nil, nil, fmt.Errorf("cannot parse front matter of %s: %w", name, err)
+		}
+		if err = yaml.Unmarshal(header, &params); err != nil {
+			return nil, nil, fmt.Errorf("cannot parse front matter of %s: %w", name, err)
+		}
+	}
+
+	base := strings.TrimSuffix(path.Base(name), path.Ext(name))
+	slug := base
+	date := fm.Date
+	if m := datedFileName.FindStringSubmatch(base); m != nil {
+		slug = m[2]
+		if date.IsZero() {
+			date, _ = time.Parse(time.DateOnly, m[1])
+		}
+	}
+	if fm.Slug != "" {
+		slug = fm.Slug
+	}
+
+	title := fm.Title
+	if title == "" {
+		title = slug
+	}
+
+	content, err := render(body)
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot render %s: %w", name, err)
+	}
+
+	var summary template.HTML
+	if fm.Summary != "" {
+		summary = template.HTML(template.HTMLEscapeString(fm.Summary))
+	} else if before, _, found := bytes.Cut(body, []byte(moreSeparator)); found {
+		summary, err = render(before)
+	} else {
+		summary, err = render(firstParagraph(body))
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot render summary of %s: %w", name, err)
+	}
+
+	return &Entry{
+		Title:   title,
+		Slug:    slug,
+		Date:    date,
+		Updated: fm.Updated,
+		Summary: summary,
+		Content: content,
+		Params:  params,
+		Draft:   fm.Draft,
+		Source:  name,
+		ModTime: modTime,
+	}, fm.Tags, nil
+}
+
+// splitFrontMatter separates the YAML front matter delimited by "---" lines
+// from the document body. Documents without front matter have a nil header.
+func splitFrontMatter(b []byte) ([]byte, []byte) {
+	b = bytes.ReplaceAll(b, []byte("\r\n"), []byte("\n"))
+	if !bytes.HasPrefix(b, []byte("---\n")) {
+		return nil, b
+	}
+
+	rest := b[4:]
+	if bytes.HasPrefix(rest, []byte("---\n")) {
+		return []byte{}, rest[4:]
+	}
+
+	header, body, found := bytes.Cut(rest, []byte("\n---\n"))
+	if !found {
+		if h, ok := bytes.CutSuffix(rest, []byte("\n---")); ok {
+			return h, nil
+		}
+		return nil, b
+	}
+
+	return header, body
+}
+
+func firstParagraph(body []byte) []byte {
+	for _, block := range bytes.Split(bytes.TrimSpace(body), []byte("\n\n")) {
+		block = bytes.TrimSpace(block)
+		if len(block) > 0 && block[0] != '#' {
+			return block
+		}
+	}
+	return nil
+}
+
+func render(source []byte) (template.HTML, error) {
+	var buf bytes.Buffer
+	if err := markdown.Convert(source, &buf); err != nil {
+		return "", err
+	}
+	return template.HTML(buf.String()), nil
+}
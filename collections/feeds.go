@@ -0,0 +1,353 @@
+package collections
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"github.com/iktech/pepper/controllers"
+	"github.com/iktech/pepper/model"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	FeedRSS         = "rss"
+	FeedAtom        = "atom"
+	DefaultFeedSize = 20
+	atomNamespace   = "http://www.w3.org/2005/Atom"
+)
+
+// siteRelativeLink matches the start of href and src attributes whose value
+// is a path on the site, but not a protocol relative URL.
+var siteRelativeLink = regexp.MustCompile(`((?:href|src)=")/([^/]|")`)
+
+// FeedDefinition describes the feeds published for a collection. A feed is
+// served only when its path is set.
+type FeedDefinition struct {
+	RSS         string
+	Atom        string
+	Title       string
+	Description string
+	Items       int
+	Full        bool
+	MaxAge      time.Duration
+	// Template, if set, renders the content of each entry with the same
+	// data, functions and includes as the entry page. Otherwise the content
+	// is the entry, or its summary, converted from Markdown.
+	Template string
+}
+
+// Feed is the controller serving the RSS or Atom feed of a collection.
+type Feed struct {
+	Collection *Collection
+	Format     string
+	Path       string
+	Model      model.Model
+	SiteURL    func(r *http.Request) string
+	// Protected, if set, reports whether the page at the path requires
+	// authentication. Protected entries are left out of the feed.
+	Protected func(path string) bool
+}
+
+type rssDocument struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Atom    string     `xml:"xmlns:atom,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	LastBuildDate string    `xml:"lastBuildDate,omitempty"`
+	Self          atomLink  `xml:"atom:link"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string   `xml:"title"`
+	Link        string   `xml:"link"`
+	GUID        rssGUID  `xml:"guid"`
+	PubDate     string   `xml:"pubDate,omitempty"`
+	Description string   `xml:"description"`
+	Categories  []string `xml:"category"`
+}
+
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+type atomDocument struct {
+	XMLName  xml.Name    `xml:"feed"`
+	Xmlns    string      `xml:"xmlns,attr"`
+	Title    string      `xml:"title"`
+	Subtitle string      `xml:"subtitle,omitempty"`
+	ID       string      `xml:"id"`
+	Updated  string      `xml:"updated"`
+	Links    []atomLink  `xml:"link"`
+	Entries  []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+}
+
+type atomEntry struct {
+	Title      string         `xml:"title"`
+	ID         string         `xml:"id"`
+	Link       atomLink       `xml:"link"`
+	Published  string         `xml:"published,omitempty"`
+	Updated    string         `xml:"updated"`
+	Summary    *atomText      `xml:"summary,omitempty"`
+	Content    *atomText      `xml:"content,omitempty"`
+	Categories []atomCategory `xml:"category"`
+}
+
+type atomText struct {
+	Type  string `xml:"type,attr"`
+	Value string `xml:",chardata"`
+}
+
+type atomCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+// Feeds returns the controllers for the feeds of the collection keyed by
+// their path. The base model renders the feed template as in Controllers,
+// the site URL function provides the base of absolute links; entries for
+// which protected, which may be nil, returns true are omitted.
+func (c *Collection) Feeds(base model.Model, siteURL func(r *http.Request) string, protected func(path string) bool) map[string]controllers.Controller {
+	routes := make(map[string]controllers.Controller)
+	def := c.Definition.Feed
+	if def.RSS != "" {
+		path := strings.Trim(def.RSS, "/")
+		routes[path] = Feed{Collection: c, Format: FeedRSS, Path: path, Model: base, SiteURL: siteURL, Protected: protected}
+	}
+	if def.Atom != "" {
+		path := strings.Trim(def.Atom, "/")
+		routes[path] = Feed{Collection: c, Format: FeedAtom, Path: path, Model: base, SiteURL: siteURL, Protected: protected}
+	}
+	return routes
+}
+
+// Handle renders the feed document.
+func (f Feed) Handle(r *http.Request) (int, string, string, *bytes.Buffer, *model.ProcessingError) {
+	base := f.SiteURL(r)
+
+	var (
+		document    interface{}
+		contentType string
+		pe          *model.ProcessingError
+	)
+	if f.Format == FeedAtom {
+		document, pe = f.atom(r, base)
+		contentType = "application/atom+xml; charset=utf-8"
+	} else {
+		document, pe = f.rss(r, base)
+		contentType = "application/rss+xml; charset=utf-8"
+	}
+	if pe != nil {
+		return 0, "", "", nil, pe
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	if err := xml.NewEncoder(&buf).Encode(document); err != nil {
+		slog.Error("cannot encode feed", "collection", f.Collection.Name, KeyError, err, KeyComponent, ComponentCollections)
+		return 0, "", "", nil, &model.ProcessingError{ResponseCode: 500}
+	}
+
+	return 200, "", contentType, &buf, nil
+}
+
+// Headers returns the caching headers of the feed. The entity tag changes
+// whenever an entry listed in the feed or the site URL changes.
+func (f Feed) Headers(r *http.Request) http.Header {
+	h := make(http.Header)
+	maxAge := f.Collection.Definition.Feed.MaxAge
+	if maxAge > 0 {
+		h.Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(maxAge.Seconds())))
+	}
+
+	hash := sha256.New()
+	hash.Write([]byte(f.Format + f.SiteURL(r)))
+	for _, e := range f.entries() {
+		hash.Write([]byte(e.Path + e.LastModified().String()))
+	}
+	h.Set("ETag", `"`+hex.EncodeToString(hash.Sum(nil))[:16]+`"`)
+
+	if updated := f.updated(); !updated.IsZero() {
+		h.Set("Last-Modified", updated.UTC().Format(http.TimeFormat))
+	}
+	return h
+}
+
+func (f Feed) rss(r *http.Request, base string) (rssDocument, *model.ProcessingError) {
+	c := f.Collection
+	def := c.Definition.Feed
+	channel := rssChannel{
+		Title:       f.title(),
+		Link:        base + c.URL(),
+		Description: def.Description,
+		Self:        atomLink{Href: base + "/" + f.Path, Rel: "self", Type: "application/rss+xml"},
+	}
+	if channel.Description == "" {
+		channel.Description = channel.Title
+	}
+	if updated := f.updated(); !updated.IsZero() {
+		channel.LastBuildDate = updated.UTC().Format(time.RFC1123Z)
+	}
+
+	for _, e := range f.entries() {
+		content, pe := f.content(r, base, e)
+		if pe != nil {
+			return rssDocument{}, pe
+		}
+		link := base + e.URL()
+		item := rssItem{
+			Title:       e.Title,
+			Link:        link,
+			GUID:        rssGUID{IsPermaLink: true, Value: link},
+			Description: content,
+		}
+		if published := e.LastPublished(); !published.IsZero() {
+			item.PubDate = published.UTC().Format(time.RFC1123Z)
+		}
+		for _, t := range e.Tags {
+			item.Categories = append(item.Categories, t.Name)
+		}
+		channel.Items = append(channel.Items, item)
+	}
+
+	return rssDocument{Version: "2.0", Atom: atomNamespace, Channel: channel}, nil
+}
+
+func (f Feed) atom(r *http.Request, base string) (atomDocument, *model.ProcessingError) {
+	c := f.Collection
+	def := c.Definition.Feed
+	// Atom requires update times, so feeds and entries without a date are
+	// given the time the collection was loaded.
+	updated := f.updated()
+	if updated.IsZero() {
+		updated = c.loaded
+	}
+	document := atomDocument{
+		Xmlns:    atomNamespace,
+		Title:    f.title(),
+		Subtitle: def.Description,
+		ID:       base + c.URL(),
+		Updated:  updated.UTC().Format(time.RFC3339),
+		Links: []atomLink{
+			{Href: base + "/" + f.Path, Rel: "self", Type: "application/atom+xml"},
+			{Href: base + c.URL(), Rel: "alternate", Type: "text/html"},
+		},
+	}
+
+	for _, e := range f.entries() {
+		content, pe := f.content(r, base, e)
+		if pe != nil {
+			return atomDocument{}, pe
+		}
+		link := base + e.URL()
+		text := &atomText{Type: "html", Value: content}
+		entry := atomEntry{
+			Title:   e.Title,
+			ID:      link,
+			Link:    atomLink{Href: link, Rel: "alternate", Type: "text/html"},
+			Updated: updated.UTC().Format(time.RFC3339),
+		}
+		if modified := e.LastModified(); !modified.IsZero() {
+			entry.Updated = modified.UTC().Format(time.RFC3339)
+		}
+		if published := e.LastPublished(); !published.IsZero() {
+			entry.Published = published.UTC().Format(time.RFC3339)
+		}
+		if def.Full {
+			entry.Content = text
+		} else {
+			entry.Summary = text
+		}
+		for _, t := range e.Tags {
+			entry.Categories = append(entry.Categories, atomCategory{Term: t.Name})
+		}
+		document.Entries = append(document.Entries, entry)
+	}
+
+	return document, nil
+}
+
+func (f Feed) entries() []*Entry {
+	items := f.Collection.Definition.Feed.Items
+	if items <= 0 {
+		items = DefaultFeedSize
+	}
+	if f.Protected == nil {
+		return f.Collection.Recent(items)
+	}
+
+	var entries []*Entry
+	for _, e := range f.Collection.Entries {
+		if len(entries) == items {
+			break
+		}
+		if !f.Protected(e.Path) {
+			entries = append(entries, e)
+		}
+	}
+	return entries
+}
+
+func (f Feed) title() string {
+	if title := f.Collection.Definition.Feed.Title; title != "" {
+		return title
+	}
+	return f.Collection.Name
+}
+
+func (f Feed) updated() time.Time {
+	var latest time.Time
+	for _, e := range f.entries() {
+		if t := e.LastModified(); t.After(latest) {
+			latest = t
+		}
+	}
+	return latest
+}
+
+// content returns the rendered entry, or its summary, with site relative
+// links made absolute so that they resolve in feed readers. Protocol
+// relative links such as //cdn.example.com/app.js are left alone.
+func (f Feed) content(r *http.Request, base string, e *Entry) (string, *model.ProcessingError) {
+	def := f.Collection.Definition.Feed
+	html := string(e.Summary)
+	if def.Full {
+		html = string(e.Content)
+	}
+
+	if def.Template != "" {
+		m := f.Model
+		m.Path = e.Path
+		m.Template = def.Template
+		_, _, _, buf, pe := m.RenderRequest(controllers.Debug, r, Page{
+			Model:      &m,
+			Collection: f.Collection,
+			Entry:      e,
+		})
+		if pe != nil {
+			slog.Error("cannot render feed entry", "collection", f.Collection.Name, "entry", e.Path, KeyError, pe.Err, KeyComponent, ComponentCollections)
+			return "", pe
+		}
+		html = buf.String()
+	}
+
+	return siteRelativeLink.ReplaceAllString(html, "${1}"+strings.ReplaceAll(base, "$", "$$")+"/${2}"), nil
+}
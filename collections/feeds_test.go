@@ -0,0 +1,127 @@
+package collections
+
+import (
+	"github.com/iktech/pepper/model"
+	"html/template"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestFeedContent(t *testing.T) {
+	tests := []struct {
+		name string
+		html string
+		want string
+	}{
+		{"site relative link", `<a href="/blog/post">post</a>`, `<a href="https://example.com/blog/post">post</a>`},
+		{"site relative image", `<img src="/img/a.png">`, `<img src="https://example.com/img/a.png">`},
+		{"site root", `<a href="/">home</a>`, `<a href="https://example.com/">home</a>`},
+		{"protocol relative", `<script src="//cdn.example.net/app.js"></script>`, `<script src="//cdn.example.net/app.js"></script>`},
+		{"absolute", `<a href="https://other.test/x">x</a>`, `<a href="https://other.test/x">x</a>`},
+		{"relative", `<a href="post">post</a>`, `<a href="post">post</a>`},
+		{"text", `href="/ in text`, `href="https://example.com/ in text`},
+	}
+	for _, tt := range tests {
+		f := Feed{Collection: &Collection{}}
+		if got, _ := f.content(nil, "https://example.com", &Entry{Summary: template.HTML(tt.html)}); got != tt.want {
+			t.Errorf("%s: content() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestFeedContentFull(t *testing.T) {
+	f := Feed{Collection: &Collection{Definition: Definition{Feed: FeedDefinition{Full: true}}}}
+	e := &Entry{Summary: "summary", Content: `<a href="/x">full</a>`}
+	want := `<a href="https://$1.test/x">full</a>`
+	if got, _ := f.content(nil, "https://$1.test", e); got != want {
+		t.Errorf("content() = %q, want %q", got, want)
+	}
+}
+
+func TestFeedContentTemplate(t *testing.T) {
+	fsys := fstest.MapFS{
+		"feed.html":   {Data: []byte(`<h1>{{ .Entry.Title }}</h1>{{ .Entry.Content }}{{ template "footer" . }}`)},
+		"footer.html": {Data: []byte(`{{ define "footer" }}<a href="/{{ .Collection.Path }}">{{ upper .Collection.Name }}</a>{{ end }}`)},
+		"broken.html": {Data: []byte(`{{ .Entry.Missing }}`)},
+	}
+	model.Functions["upper"] = strings.ToUpper
+	defer delete(model.Functions, "upper")
+
+	c := &Collection{Name: "blog", Path: "blog", Definition: Definition{Feed: FeedDefinition{Full: true, Template: "feed.html"}}}
+	f := Feed{Collection: c, Model: model.Model{TemplatesDirectory: fsys, Includes: []string{"footer.html"}}}
+	e := &Entry{Title: "Post", Path: "blog/post", Content: `<img src="/a.png">`}
+	got, pe := f.content(nil, "https://example.com", e)
+	if want := `<h1>Post</h1><img src="https://example.com/a.png"><a href="https://example.com/blog">BLOG</a>`; pe != nil || got != want {
+		t.Errorf("content() = %q, %v, want %q", got, pe, want)
+	}
+
+	c.Definition.Feed.Template = "broken.html"
+	if _, pe := f.content(nil, "https://example.com", e); pe == nil || pe.ResponseCode != 500 {
+		t.Errorf("broken template: error %v, want a 500", pe)
+	}
+}
+
+func TestAtomUpdated(t *testing.T) {
+	loaded := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	date := time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		entries []*Entry
+		feed    string
+		entry   string
+	}{
+		{"no entries", nil, "2026-03-01T12:00:00Z", ""},
+		{"undated entry", []*Entry{{Path: "blog/a"}}, "2026-03-01T12:00:00Z", "2026-03-01T12:00:00Z"},
+		{"dated entry", []*Entry{{Path: "blog/a", Date: date}}, "2026-02-01T00:00:00Z", "2026-02-01T00:00:00Z"},
+	}
+	for _, tt := range tests {
+		c := &Collection{Name: "blog", Path: "blog", Entries: tt.entries, loaded: loaded}
+		document, _ := Feed{Collection: c, Format: FeedAtom, Path: "blog/atom.xml"}.atom(nil, "https://example.com")
+		if document.Updated != tt.feed {
+			t.Errorf("%s: feed updated = %q, want %q", tt.name, document.Updated, tt.feed)
+		}
+		if strings.HasPrefix(document.Updated, "0001") {
+			t.Errorf("%s: feed updated is the zero time", tt.name)
+		}
+		if tt.entry != "" && document.Entries[0].Updated != tt.entry {
+			t.Errorf("%s: entry updated = %q, want %q", tt.name, document.Entries[0].Updated, tt.entry)
+		}
+	}
+}
+
+func TestFeedSkipsProtectedEntries(t *testing.T) {
+	c := &Collection{
+		Name: "blog",
+		Path: "blog",
+		Entries: []*Entry{
+			{Path: "blog/members/a"},
+			{Path: "blog/b"},
+			{Path: "blog/members/c"},
+			{Path: "blog/d"},
+			{Path: "blog/e"},
+		},
+		Definition: Definition{Feed: FeedDefinition{Items: 2}},
+	}
+	protected := func(path string) bool { return strings.HasPrefix(path, "blog/members/") }
+
+	tests := []struct {
+		name      string
+		protected func(string) bool
+		want      []string
+	}{
+		{"no protection", nil, []string{"blog/members/a", "blog/b"}},
+		{"protected entries", protected, []string{"blog/b", "blog/d"}},
+	}
+	for _, tt := range tests {
+		var paths []string
+		for _, e := range (Feed{Collection: c, Protected: tt.protected}).entries() {
+			paths = append(paths, e.Path)
+		}
+		if strings.Join(paths, " ") != strings.Join(tt.want, " ") {
+			t.Errorf("%s: entries = %q, want %q", tt.name, paths, tt.want)
+		}
+	}
+}
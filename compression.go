@@ -0,0 +1,254 @@
+package pepper
+
+import (
+	"compress/gzip"
+	"context"
+	"github.com/andybalholm/brotli"
+	"github.com/spf13/viper"
+	"io"
+	"mime"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+)
+
+var (
+	gzipWriters = sync.Pool{New: func() interface{} {
+		return gzip.NewWriter(io.Discard)
+	}}
+	brotliWriters = sync.Pool{New: func() interface{} {
+		return brotli.NewWriterLevel(io.Discard, brotli.DefaultCompression)
+	}}
+)
+
+// compressionKey is the context key of the compressResponseWriter of a
+// request.
+const compressionKey key = 1
+
+type compressionConfig struct {
+	minSize int
+	types   []string
+	exclude []string
+}
+
+// compressResponseWriter buffers the beginning of the response body until
+// it can decide whether to compress it: the body has to reach the minimum
+// size, its content type has to be allowed and the client has to accept
+// one of the supported encodings.
+type compressResponseWriter struct {
+	http.ResponseWriter
+	r           *http.Request
+	config      *compressionConfig
+	lrw         *loggingResponseWriter
+	code        int
+	buf         []byte
+	decided     bool
+	encoding    string
+	encoder     io.WriteCloser
+	wroteHeader bool
+	disabled    bool
+}
+
+// Compression compresses response bodies with brotli or gzip. Responses
+// already carrying a Content-Encoding, such as precompressed static files,
+// are left alone, as are routes excluded by http.compression.exclude and
+// controllers opting out through controllers.Compressible. It must be placed
+// inside Logging so that the access log records both the bytes on the wire
+// and the uncompressed size. The buffered body of a handler that panics is
+// dropped, so that Recovery inside it aborts the response rather than a
+// partial body being sent.
+func Compression() func(http.Handler) http.Handler {
+	config := &compressionConfig{
+		minSize: viper.GetInt("http.compression.minSize"),
+		types:   viper.GetStringSlice("http.compression.types"),
+		exclude: viper.GetStringSlice("http.compression.exclude"),
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !viper.GetBool("http.compression.enabled") || r.Method == http.MethodHead || config.excluded(r.URL.Path) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			cw := &compressResponseWriter{ResponseWriter: w, r: r, config: config, code: http.StatusOK}
+			cw.lrw, _ = w.(*loggingResponseWriter)
+			defer func() {
+				if v := recover(); v != nil {
+					cw.abort()
+					panic(v)
+				}
+				cw.close()
+			}()
+			next.ServeHTTP(cw, r.WithContext(context.WithValue(r.Context(), compressionKey, cw)))
+		})
+	}
+}
+
+// disableCompression sends the response to the request uncompressed. It
+// has no effect once the response has been started.
+func disableCompression(r *http.Request) {
+	if cw, ok := r.Context().Value(compressionKey).(*compressResponseWriter); ok {
+		cw.disabled = true
+	}
+}
+
+func (c *compressionConfig) excluded(urlPath string) bool {
+	name := strings.TrimPrefix(urlPath, "/")
+	for _, pattern := range c.exclude {
+		if matchPattern(pattern, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *compressionConfig) compressible(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return slices.Contains(c.types, mediaType)
+}
+
+func (cw *compressResponseWriter) WriteHeader(code int) {
+	if cw.wroteHeader || cw.decided {
+		return
+	}
+	cw.code = code
+	cw.wroteHeader = true
+
+	if code < 200 || code == http.StatusNoContent || code == http.StatusNotModified {
+		_ = cw.decide(false)
+	}
+}
+
+func (cw *compressResponseWriter) Write(b []byte) (int, error) {
+	if cw.lrw != nil {
+		cw.lrw.uncompressedSize += len(b)
+	}
+
+	if !cw.decided {
+		cw.buf = append(cw.buf, b...)
+		if len(cw.buf) < cw.config.minSize {
+			return len(b), nil
+		}
+		if err := cw.decide(true); err != nil {
+			return 0, err
+		}
+		return len(b), nil
+	}
+
+	if cw.encoder != nil {
+		return cw.encoder.Write(b)
+	}
+	return cw.ResponseWriter.Write(b)
+}
+
+// Flush sends the buffered body, compressing it regardless of its size if
+// the content type allows, so that streamed responses are not held back.
+func (cw *compressResponseWriter) Flush() {
+	if !cw.decided {
+		_ = cw.decide(true)
+	}
+	if f, ok := cw.encoder.(interface{ Flush() error }); ok {
+		_ = f.Flush()
+	}
+	if f, ok := cw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// decide writes the response header, compressing the body if allowed, and
+// then writes the buffered part of the body.
+func (cw *compressResponseWriter) decide(compress bool) error {
+	cw.decided = true
+	h := cw.Header()
+
+	eligible := compress && !cw.disabled &&
+		(cw.code == http.StatusOK || cw.code >= 400) &&
+		h.Get("Content-Encoding") == "" &&
+		h.Get("Content-Range") == ""
+	if eligible {
+		if h.Get("Content-Type") == "" {
+			h.Set("Content-Type", http.DetectContentType(cw.buf))
+		}
+		eligible = cw.config.compressible(h.Get("Content-Type"))
+	}
+
+	if eligible {
+		addVary(h, "Accept-Encoding")
+		switch {
+		case acceptsEncoding(cw.r, "br"):
+			bw := brotliWriters.Get().(*brotli.Writer)
+			bw.Reset(cw.ResponseWriter)
+			cw.encoder, cw.encoding = bw, "br"
+		case acceptsEncoding(cw.r, "gzip"):
+			gw := gzipWriters.Get().(*gzip.Writer)
+			gw.Reset(cw.ResponseWriter)
+			cw.encoder, cw.encoding = gw, "gzip"
+		}
+	}
+
+	if cw.encoder != nil {
+		h.Set("Content-Encoding", cw.encoding)
+		h.Del("Content-Length")
+		if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			h.Set("ETag", "W/"+etag)
+		}
+	}
+
+	cw.ResponseWriter.WriteHeader(cw.code)
+
+	if len(cw.buf) == 0 {
+		return nil
+	}
+	buf := cw.buf
+	cw.buf = nil
+	var err error
+	if cw.encoder != nil {
+		_, err = cw.encoder.Write(buf)
+	} else {
+		_, err = cw.ResponseWriter.Write(buf)
+	}
+	return err
+}
+
+// abort drops the response of a handler that panicked. The buffered part
+// of the body is discarded and the compressed stream is left unterminated,
+// so that a partial response is neither sent with a 200 nor taken for a
+// complete one.
+func (cw *compressResponseWriter) abort() {
+	cw.buf = nil
+	cw.decided = true
+	cw.release()
+}
+
+func (cw *compressResponseWriter) close() {
+	if !cw.decided {
+		if !cw.wroteHeader && len(cw.buf) == 0 {
+			return
+		}
+		_ = cw.decide(false)
+	}
+
+	if cw.encoder == nil {
+		return
+	}
+	_ = cw.encoder.Close()
+	cw.release()
+}
+
+// release returns the encoder to its pool.
+func (cw *compressResponseWriter) release() {
+	switch e := cw.encoder.(type) {
+	case *gzip.Writer:
+		e.Reset(io.Discard)
+		gzipWriters.Put(e)
+	case *brotli.Writer:
+		e.Reset(io.Discard)
+		brotliWriters.Put(e)
+	}
+	cw.encoder = nil
+}
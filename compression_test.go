@@ -0,0 +1,73 @@
+package pepper
+
+import (
+	"bytes"
+	"github.com/iktech/pepper/controllers"
+	"github.com/iktech/pepper/model"
+	"github.com/spf13/viper"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// page is a controller rendering a fixed HTML page.
+type page struct {
+	body string
+}
+
+func (p page) Handle(*http.Request) (int, string, string, *bytes.Buffer, *model.ProcessingError) {
+	return 200, "", "text/html", bytes.NewBufferString(p.body), nil
+}
+
+// uncompressedPage is a page opting out of compression.
+type uncompressedPage struct {
+	page
+}
+
+func (uncompressedPage) Compressible() bool {
+	return false
+}
+
+func TestCompressionOptOut(t *testing.T) {
+	t.Cleanup(viper.Reset)
+	viper.Set("http.compression.enabled", true)
+	viper.Set("http.compression.minSize", 16)
+	viper.Set("http.compression.types", []string{"text/html"})
+	viper.Set("http.compression.exclude", []string{"excluded"})
+
+	body := "<p>" + strings.Repeat("compress me ", 100) + "</p>"
+	s := Service{routerMap: map[string]controllers.Controller{
+		"compressed":   page{body},
+		"uncompressed": uncompressedPage{page{body}},
+		"excluded":     page{body},
+		"small":        page{"<p>tiny</p>"},
+	}}
+	handler := Compression()(s)
+
+	tests := []struct {
+		path     string
+		encoding string
+	}{
+		{"/compressed", "gzip"},
+		{"/uncompressed", ""},
+		{"/excluded", ""},
+		{"/small", ""},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", tt.path, nil)
+		r.Header.Set("Accept-Encoding", "gzip")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, r)
+
+		if got := rec.Header().Get("Content-Encoding"); got != tt.encoding {
+			t.Errorf("%s: Content-Encoding = %q, want %q", tt.path, got, tt.encoding)
+		}
+		if tt.encoding == "" && tt.path != "/small" && rec.Body.String() != body {
+			t.Errorf("%s: body is not sent as is", tt.path)
+		}
+		if got := rec.Header().Get("Content-Type"); got != "text/html" {
+			t.Errorf("%s: Content-Type = %q, want text/html", tt.path, got)
+		}
+	}
+}
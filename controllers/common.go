@@ -16,6 +16,6 @@ type Model struct {
    *model.Model
 }
 
-func (m Model) Handle(_ *http.Request) (int, string, string, *bytes.Buffer, *model.ProcessingError) {
-    return m.Render(Debug, m)
+func (m Model) Handle(r *http.Request) (int, string, string, *bytes.Buffer, *model.ProcessingError) {
+    return m.RenderRequest(Debug, r, m)
 }
@@ -0,0 +1,79 @@
+package controllers
+
+import (
+	"net/http"
+	"time"
+)
+
+// Modified is implemented by controllers which know when the content they
+// render was last changed.
+type Modified interface {
+	LastModified() time.Time
+}
+
+// SitemapOptions controls how a route is listed in the sitemap. A zero
+// priority and an empty change frequency are omitted from the sitemap.
+type SitemapOptions struct {
+	Priority   float64
+	ChangeFreq string
+	Exclude    bool
+}
+
+// Sitemapped is implemented by controllers which provide their own sitemap
+// settings. Settings from the configuration take precedence.
+type Sitemapped interface {
+	SitemapOptions() SitemapOptions
+}
+
+// Indexed is implemented by controllers which restrict how search engines
+// index their pages. The directives are sent in the X-Robots-Tag header.
+type Indexed interface {
+	RobotsTag() string
+}
+
+// HeaderProvider is implemented by controllers which add headers to their
+// responses, for example to control caching. When the headers include an
+// ETag or Last-Modified, conditional requests are answered with 304.
+type HeaderProvider interface {
+	Headers(r *http.Request) http.Header
+}
+
+// Compressible is implemented by controllers which decide whether their
+// responses may be compressed, for example to send event streams or bodies
+// which are compressed already as they are. Responses are compressed unless
+// Compressible returns false or http.compression.exclude matches the route.
+type Compressible interface {
+	Compressible() bool
+}
+
+// Protection restricts a route to the users of an auth group, or of the
+// password file http.password.file if Group is empty. An empty realm stands
+// for http.auth.realm and an empty user list admits every user of the file.
+type Protection struct {
+	Group string
+	Realm string
+	Users []string
+}
+
+// Protected is implemented by controllers which require authentication.
+// Rules in http.auth.rules matching the route take precedence.
+type Protected interface {
+	Protection() Protection
+}
+
+// protected marks a controller as requiring authentication.
+type protected struct {
+	Controller
+	protection Protection
+}
+
+func (p protected) Protection() Protection {
+	return p.protection
+}
+
+// Protect returns the controller marked as requiring authentication, so that
+// the customize callback can protect the controllers it creates or receives.
+// Other optional interfaces of the controller are not kept.
+func Protect(c Controller, p Protection) Controller {
+	return protected{Controller: c, protection: p}
+}
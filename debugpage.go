@@ -0,0 +1,89 @@
+package pepper
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/iktech/pepper/controllers"
+	"github.com/iktech/pepper/model"
+	"github.com/spf13/viper"
+	"html/template"
+	"log/slog"
+	"net/http"
+)
+
+var templateErrorPage = template.Must(template.New("template-error").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Template error in {{ .Error.Template }}</title>
+<style>
+body{font-family:sans-serif;margin:2em}
+pre{background:#f4f4f4;padding:1em;overflow:auto}
+.excerpt{border-collapse:collapse;font-family:monospace;width:100%;background:#f4f4f4}
+.excerpt td{padding:0 .5em;white-space:pre}
+.excerpt .number{color:#888;text-align:right;width:3em}
+.excerpt .error{background:#fdd}
+</style>
+</head>
+<body>
+<h1>Cannot {{ .Error.Phase }} template {{ .Error.Template }}</h1>
+<pre>{{ .Error.Err }}</pre>
+{{ if .Error.File }}<h2>{{ .Error.File }}, line {{ .Error.Line }}{{ if .Error.Column }}, column {{ .Error.Column }}{{ end }}</h2>
+{{ if .Error.Excerpt }}<table class="excerpt">
+{{ range .Error.Excerpt }}<tr{{ if .IsError }} class="error"{{ end }}><td class="number">{{ .Number }}</td><td>{{ .Text }}</td></tr>
+{{ end }}</table>{{ end }}{{ end }}
+<h2>Include chain</h2>
+<ol>
+<li>{{ .Error.Template }}</li>
+{{ range .Error.Includes }}<li>{{ . }}</li>
+{{ end }}</ol>
+<h2>Data</h2>
+<pre>{{ .Data }}</pre>
+<p>{{ .Method }} {{ .Path }}, request {{ .RequestID }}</p>
+</body>
+</html>
+`))
+
+// errorOverlayEnabled reports whether template errors and panics are shown
+// in detail. This requires both debug mode and http.debug.errorOverlay, so
+// that the overlay cannot be switched on in production by debug mode alone.
+func errorOverlayEnabled() bool {
+	return controllers.Debug && viper.GetBool("http.debug.errorOverlay")
+}
+
+// writeTemplateError responds with a page describing the template error of
+// the processing error, if the error overlay is enabled and the client
+// accepts HTML. It reports whether a response has been written.
+func writeTemplateError(w http.ResponseWriter, r *http.Request, pe model.ProcessingError) bool {
+	var te *model.TemplateError
+	if !errorOverlayEnabled() || !errors.As(pe.Err, &te) || errorContentType(r) != contentTypeHTML {
+		return false
+	}
+
+	requestID, _ := r.Context().Value(requestIDKey).(string)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(pe.ResponseCode)
+	err := templateErrorPage.Execute(w, map[string]interface{}{
+		"Error":     te,
+		"Data":      dumpData(te.Data),
+		"Method":    r.Method,
+		"Path":      r.URL.Path,
+		"RequestID": requestID,
+	})
+	if err != nil {
+		slog.Error("cannot write response body", KeyError, err, KeyComponent, ComponentService)
+	}
+	return true
+}
+
+// dumpData formats the data of a template as indented JSON, which follows
+// pointers, or with fmt if it cannot be encoded, for example because of
+// cycles.
+func dumpData(data interface{}) string {
+	b, err := json.MarshalIndent(data, "", "  ")
+	if err != nil {
+		return fmt.Sprintf("%+v", data)
+	}
+	return string(b)
+}
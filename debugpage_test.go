@@ -0,0 +1,44 @@
+package pepper
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDumpData(t *testing.T) {
+	type author struct {
+		Name string
+	}
+	type post struct {
+		Title  string
+		Author *author
+	}
+	type node struct {
+		Name string
+		Next *node
+	}
+	cycle := &node{Name: "a"}
+	cycle.Next = cycle
+
+	tests := []struct {
+		name string
+		data interface{}
+		want []string
+	}{
+		{"pointer", &post{Title: "Hello", Author: &author{Name: "Ann"}}, []string{`"Title": "Hello"`, `"Name": "Ann"`}},
+		{"map", map[string]interface{}{"count": 3}, []string{`"count": 3`}},
+		{"nil", nil, []string{"null"}},
+		{"cycle", cycle, []string{"Name:a"}},
+	}
+	for _, tt := range tests {
+		got := dumpData(tt.data)
+		for _, want := range tt.want {
+			if !strings.Contains(got, want) {
+				t.Errorf("%s: dumpData() = %s, want it to contain %s", tt.name, got, want)
+			}
+		}
+		if tt.name == "pointer" && strings.Contains(got, "0x") {
+			t.Errorf("%s: dumpData() = %s, shows an address", tt.name, got)
+		}
+	}
+}
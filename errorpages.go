@@ -0,0 +1,109 @@
+package pepper
+
+import (
+	"github.com/spf13/viper"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ErrorPageScope overrides error pages for the requests it matches: those
+// for a virtual host, below a path prefix or for a group of routes. Routes
+// ending with "*" match by prefix. All conditions given have to match.
+type ErrorPageScope struct {
+	Name   string            `mapstructure:"name"`
+	Host   string            `mapstructure:"host"`
+	Prefix string            `mapstructure:"prefix"`
+	Routes []string          `mapstructure:"routes"`
+	Pages  map[string]string `mapstructure:"pages"`
+}
+
+type errorPageScope struct {
+	ErrorPageScope
+	pages map[int]*ErrorPageDefinition
+}
+
+var errorPageScopes []errorPageScope
+
+// loadErrorPageScopes reads the scoped error pages from http.errorPageScopes.
+func loadErrorPageScopes() []errorPageScope {
+	var config []ErrorPageScope
+	if err := viper.UnmarshalKey("http.errorPageScopes", &config); err != nil {
+		slog.Error("cannot read error page scopes", KeyError, err, KeyComponent, ComponentService)
+		os.Exit(1)
+	}
+
+	scopes := make([]errorPageScope, 0, len(config))
+	for _, c := range config {
+		scope := errorPageScope{ErrorPageScope: c, pages: make(map[int]*ErrorPageDefinition)}
+		scope.Host = strings.ToLower(c.Host)
+		scope.Prefix = strings.Trim(c.Prefix, "/")
+		for key, name := range c.Pages {
+			code, err := strconv.Atoi(key)
+			if err != nil {
+				slog.Error("unexpected error code in error page scope", "scope", c.Name, "code", key, KeyComponent, ComponentService)
+				os.Exit(1)
+			}
+			scope.pages[code] = &ErrorPageDefinition{
+				Name:       name,
+				IsTemplate: strings.HasSuffix(name, ".gohtml"),
+			}
+		}
+		scopes = append(scopes, scope)
+	}
+	return scopes
+}
+
+// errorPage returns the definition of the error page for the status code,
+// taken from the first scope matching the request which defines one, or
+// from the global error pages.
+func errorPage(code int, r *http.Request) *ErrorPageDefinition {
+	if r != nil {
+		for _, scope := range errorPageScopes {
+			if definition := scope.pages[code]; definition != nil && scope.matches(r) {
+				return definition
+			}
+		}
+	}
+	return ErrorPages[code]
+}
+
+func (s errorPageScope) matches(r *http.Request) bool {
+	if s.Host != "" {
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			host = r.Host
+		}
+		host = strings.ToLower(host)
+		if suffix, found := strings.CutPrefix(s.Host, "*."); found {
+			if !strings.HasSuffix(host, "."+suffix) {
+				return false
+			}
+		} else if host != s.Host {
+			return false
+		}
+	}
+
+	path := strings.Trim(r.URL.Path, "/")
+	if s.Prefix != "" && path != s.Prefix && !strings.HasPrefix(path, s.Prefix+"/") {
+		return false
+	}
+
+	if len(s.Routes) == 0 {
+		return true
+	}
+	for _, route := range s.Routes {
+		route = strings.TrimPrefix(route, "/")
+		if prefix, found := strings.CutSuffix(route, "*"); found {
+			if strings.HasPrefix(path, prefix) {
+				return true
+			}
+		} else if strings.Trim(route, "/") == path {
+			return true
+		}
+	}
+	return false
+}
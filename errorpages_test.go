@@ -0,0 +1,63 @@
+package pepper
+
+import (
+	"github.com/spf13/viper"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestErrorPageScopes(t *testing.T) {
+	t.Cleanup(viper.Reset)
+	previousPages, previousScopes := ErrorPages, errorPageScopes
+	t.Cleanup(func() { ErrorPages, errorPageScopes = previousPages, previousScopes })
+
+	viper.Set("http.errorPageScopes", []map[string]interface{}{
+		{"name": "docs", "host": "Docs.Example.com", "pages": map[string]string{"404": "docs-404.html"}},
+		{"name": "tenants", "host": "*.tenants.example.com", "pages": map[string]string{"404": "tenant-404.html"}},
+		{"name": "api", "prefix": "/api/", "pages": map[string]string{"404": "api-404.gohtml", "500": "api-500.html"}},
+		{"name": "shop", "routes": []string{"/cart", "checkout/*"}, "pages": map[string]string{"404": "shop-404.html"}},
+		{"name": "admin", "host": "admin.example.com", "prefix": "users", "pages": map[string]string{"404": "admin-404.html"}},
+	})
+	errorPageScopes = loadErrorPageScopes()
+	ErrorPages = map[int]*ErrorPageDefinition{
+		404: {Name: "404.html"},
+		500: {Name: "500.html"},
+	}
+
+	tests := []struct {
+		name string
+		url  string
+		code int
+		want string
+	}{
+		{name: "global", url: "http://example.com/about", code: 404, want: "404.html"},
+		{name: "host", url: "http://docs.example.com/about", code: 404, want: "docs-404.html"},
+		{name: "host with port", url: "http://docs.example.com:8888/about", code: 404, want: "docs-404.html"},
+		{name: "wildcard host", url: "http://acme.tenants.example.com/", code: 404, want: "tenant-404.html"},
+		{name: "wildcard host apex", url: "http://tenants.example.com/", code: 404, want: "404.html"},
+		{name: "prefix", url: "http://example.com/api/users", code: 404, want: "api-404.gohtml"},
+		{name: "prefix itself", url: "http://example.com/api", code: 404, want: "api-404.gohtml"},
+		{name: "prefix of a word", url: "http://example.com/apis", code: 404, want: "404.html"},
+		{name: "other code in scope", url: "http://example.com/api/users", code: 500, want: "api-500.html"},
+		{name: "code not in scope", url: "http://docs.example.com/about", code: 500, want: "500.html"},
+		{name: "first matching scope", url: "http://docs.example.com/api/users", code: 404, want: "docs-404.html"},
+		{name: "route", url: "http://example.com/cart", code: 404, want: "shop-404.html"},
+		{name: "route pattern", url: "http://example.com/checkout/pay", code: 404, want: "shop-404.html"},
+		{name: "other route", url: "http://example.com/carts", code: 404, want: "404.html"},
+		{name: "host and prefix", url: "http://admin.example.com/users/1", code: 404, want: "admin-404.html"},
+		{name: "host without prefix", url: "http://admin.example.com/about", code: 404, want: "404.html"},
+	}
+	for _, tt := range tests {
+		got := errorPage(tt.code, httptest.NewRequest("GET", tt.url, nil))
+		if got == nil || got.Name != tt.want {
+			t.Errorf("%s: errorPage(%d, %s) = %v, want %s", tt.name, tt.code, tt.url, got, tt.want)
+		}
+	}
+
+	if got := errorPage(404, nil); got == nil || got.Name != "404.html" {
+		t.Errorf("errorPage(404, nil) = %v, want 404.html", got)
+	}
+	if page := errorPageScopes[2].pages[404]; !page.IsTemplate {
+		t.Errorf("%s is not a template", page.Name)
+	}
+}
@@ -0,0 +1,176 @@
+package pepper
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/iktech/pepper/controllers"
+	"github.com/iktech/pepper/model"
+	"html"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	contentTypeHTML    = "text/html"
+	contentTypeJSON    = "application/json"
+	contentTypeProblem = "application/problem+json"
+)
+
+const minimalErrorPageFormat = `<!DOCTYPE html>
+<html lang="en">
+<head>
+    <meta charset="UTF-8">
+    <title>%[1]s</title>
+</head>
+<body>
+    <h1>%[1]s</h1>
+</body>
+</html>
+`
+
+// Problem is an RFC 9457 problem details object, sent instead of the error
+// page to clients preferring JSON.
+type Problem struct {
+	Type      string `json:"type"`
+	Title     string `json:"title"`
+	Status    int    `json:"status"`
+	Detail    string `json:"detail,omitempty"`
+	Instance  string `json:"instance,omitempty"`
+	RequestID string `json:"requestId,omitempty"`
+}
+
+// writeError responds with the error in the format negotiated with the
+// client: the error page for browsers and problem details for API clients.
+// Browsers get a minimal page with the status text for codes without an
+// error page, such as 429, or if the error page cannot be rendered.
+func writeError(w http.ResponseWriter, r *http.Request, pe model.ProcessingError) {
+	addVary(w.Header(), "Accept")
+	if writeTemplateError(w, r, pe) {
+		return
+	}
+	contentType := errorContentType(r)
+
+	var (
+		b   []byte
+		err error
+	)
+	if contentType == contentTypeHTML {
+		b, err = GetRequestErrorPageContent(pe, r)
+		if err != nil {
+			slog.Error("cannot read error page content", KeyError, err, KeyComponent, ComponentService)
+		}
+		if len(b) == 0 {
+			b = minimalErrorPage(pe.ResponseCode)
+		}
+	}
+	if contentType != contentTypeHTML {
+		b, err = json.Marshal(newProblem(r, pe))
+		if err != nil {
+			slog.Error("cannot encode problem details", KeyError, err, KeyComponent, ComponentService)
+		}
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(pe.ResponseCode)
+	_, err = w.Write(b)
+	if err != nil {
+		slog.Error("cannot write response body", KeyError, err, KeyComponent, ComponentService)
+	}
+}
+
+// minimalErrorPage returns the page sent to browsers when there is no error
+// page for the code.
+func minimalErrorPage(code int) []byte {
+	return []byte(fmt.Sprintf(minimalErrorPageFormat, html.EscapeString(strconv.Itoa(code)+" "+http.StatusText(code))))
+}
+
+// newProblem describes the error as problem details. The detail is taken
+// from the data of the error if it is a string, an error or a map with a
+// "detail" entry. Otherwise, in debug mode only, like the error message
+// given to error page templates, it is the message of the originating error,
+// as it may reveal internals.
+func newProblem(r *http.Request, pe model.ProcessingError) Problem {
+	p := Problem{
+		Type:     "about:blank",
+		Title:    http.StatusText(pe.ResponseCode),
+		Status:   pe.ResponseCode,
+		Instance: r.URL.Path,
+	}
+	if r.RequestURI != "" {
+		p.Instance, _, _ = strings.Cut(r.RequestURI, "?")
+	}
+	p.RequestID, _ = r.Context().Value(requestIDKey).(string)
+
+	switch data := pe.Data.(type) {
+	case string:
+		p.Detail = data
+	case error:
+		p.Detail = data.Error()
+	case map[string]interface{}:
+		p.Detail, _ = data["detail"].(string)
+	case map[string]string:
+		p.Detail = data["detail"]
+	}
+	if p.Detail == "" && controllers.Debug && pe.Err != nil {
+		p.Detail = pe.Err.Error()
+	}
+	return p
+}
+
+// errorContentType negotiates the format of an error response from the
+// Accept header. HTML is preferred unless JSON is accepted with a higher
+// quality, so browsers and clients sending no or a wildcard Accept header
+// get the error page.
+func errorContentType(r *http.Request) string {
+	html, jsonQuality, problem := acceptQuality(r, contentTypeHTML), acceptQuality(r, contentTypeJSON), acceptQuality(r, contentTypeProblem)
+	if html >= jsonQuality && html >= problem {
+		return contentTypeHTML
+	}
+	if problem >= jsonQuality {
+		return contentTypeProblem
+	}
+	return contentTypeJSON
+}
+
+// acceptQuality returns the quality the Accept header of the request gives
+// to the media type, taking the most specific matching range. A missing
+// header accepts everything.
+func acceptQuality(r *http.Request, mediaType string) float64 {
+	values := r.Header.Values("Accept")
+	if len(values) == 0 {
+		return 1
+	}
+
+	quality, specificity := 0.0, -1
+	main, _, _ := strings.Cut(mediaType, "/")
+	for _, header := range values {
+		for _, part := range strings.Split(header, ",") {
+			accepted, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+			if err != nil {
+				continue
+			}
+
+			s := -1
+			switch accepted {
+			case mediaType:
+				s = 2
+			case main + "/*":
+				s = 1
+			case "*/*":
+				s = 0
+			}
+			if s <= specificity {
+				continue
+			}
+
+			specificity, quality = s, 1
+			if q, err := strconv.ParseFloat(params["q"], 64); err == nil {
+				quality = q
+			}
+		}
+	}
+	return quality
+}
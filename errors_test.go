@@ -0,0 +1,156 @@
+package pepper
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/iktech/pepper/controllers"
+	"github.com/iktech/pepper/model"
+	"github.com/spf13/viper"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAcceptQuality(t *testing.T) {
+	tests := []struct {
+		accept    []string
+		mediaType string
+		want      float64
+	}{
+		{nil, "text/html", 1},
+		{[]string{"text/html"}, "text/html", 1},
+		{[]string{"text/html"}, "application/json", 0},
+		{[]string{"application/json;q=0.8"}, "application/json", 0.8},
+		{[]string{"text/*;q=0.5"}, "text/html", 0.5},
+		{[]string{"*/*;q=0.1"}, "application/json", 0.1},
+		{[]string{"*/*;q=0.1, text/html;q=0.9"}, "text/html", 0.9},
+		{[]string{"text/html;q=0.9, */*;q=0.1"}, "text/html", 0.9},
+		{[]string{"text/*;q=0.2, text/html;q=0"}, "text/html", 0},
+		{[]string{"application/json", "text/html;q=0.3"}, "text/html", 0.3},
+		{[]string{"text/html;q=bad"}, "text/html", 1},
+		{[]string{"not a media type"}, "text/html", 0},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		for _, value := range tt.accept {
+			r.Header.Add("Accept", value)
+		}
+		if got := acceptQuality(r, tt.mediaType); got != tt.want {
+			t.Errorf("acceptQuality(%q, %s) = %v, want %v", tt.accept, tt.mediaType, got, tt.want)
+		}
+	}
+}
+
+func TestErrorContentType(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   string
+	}{
+		{"", contentTypeHTML},
+		{"*/*", contentTypeHTML},
+		{"text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8", contentTypeHTML},
+		{"application/json", contentTypeJSON},
+		{"application/problem+json", contentTypeProblem},
+		{"application/json, application/problem+json", contentTypeProblem},
+		{"application/json, application/problem+json;q=0.5", contentTypeJSON},
+		{"application/json;q=0.5, text/html", contentTypeHTML},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		if tt.accept != "" {
+			r.Header.Set("Accept", tt.accept)
+		}
+		if got := errorContentType(r); got != tt.want {
+			t.Errorf("errorContentType(%q) = %s, want %s", tt.accept, got, tt.want)
+		}
+	}
+}
+
+func TestNewProblem(t *testing.T) {
+	previous := controllers.Debug
+	t.Cleanup(func() { controllers.Debug = previous })
+
+	tests := []struct {
+		name  string
+		debug bool
+		data  interface{}
+		err   error
+		want  string
+	}{
+		{name: "string", data: "database is down", want: "database is down"},
+		{name: "error", data: errors.New("connection refused"), want: "connection refused"},
+		{name: "map", data: map[string]interface{}{"detail": "bad input"}, want: "bad input"},
+		{name: "string map", data: map[string]string{"detail": "bad input"}, want: "bad input"},
+		{name: "other data", data: 42, want: ""},
+		{name: "internal error", err: errors.New("template: page.gohtml:3"), want: ""},
+		{name: "internal error in debug mode", debug: true, err: errors.New("template: page.gohtml:3"), want: "template: page.gohtml:3"},
+		{name: "data before internal error", debug: true, data: "bad input", err: errors.New("template: page.gohtml:3"), want: "bad input"},
+	}
+	for _, tt := range tests {
+		controllers.Debug = tt.debug
+		r := httptest.NewRequest("GET", "/orders/1?secret=x", nil)
+		p := newProblem(r, model.ProcessingError{ResponseCode: 500, Data: tt.data, Err: tt.err})
+		if p.Detail != tt.want {
+			t.Errorf("%s: detail = %q, want %q", tt.name, p.Detail, tt.want)
+		}
+		if p.Status != 500 || p.Title != "Internal Server Error" || p.Type != "about:blank" {
+			t.Errorf("%s: problem = %+v", tt.name, p)
+		}
+		if p.Instance != "/orders/1" {
+			t.Errorf("%s: instance = %q, want /orders/1", tt.name, p.Instance)
+		}
+	}
+}
+
+func TestWriteErrorWithoutErrorPage(t *testing.T) {
+	t.Cleanup(viper.Reset)
+	previous := ErrorPages
+	t.Cleanup(func() { ErrorPages = previous })
+	ErrorPages = map[int]*ErrorPageDefinition{}
+
+	rec := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/login", nil)
+	r.Header.Set("Accept", "text/html")
+	writeError(rec, r, model.ProcessingError{ResponseCode: 429})
+
+	if rec.Code != 429 {
+		t.Errorf("status = %d, want 429", rec.Code)
+	}
+	if got := rec.Header().Get("Content-Type"); got != contentTypeHTML {
+		t.Errorf("Content-Type = %q, want %s", got, contentTypeHTML)
+	}
+	if !strings.Contains(rec.Body.String(), "<title>429 Too Many Requests</title>") {
+		t.Errorf("body = %q, want the status text", rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	r.Header.Set("Accept", contentTypeProblem)
+	writeError(rec, r, model.ProcessingError{ResponseCode: 429})
+	if got := rec.Header().Get("Content-Type"); got != contentTypeProblem {
+		t.Errorf("Content-Type = %q, want %s", got, contentTypeProblem)
+	}
+	var p Problem
+	if err := json.Unmarshal(rec.Body.Bytes(), &p); err != nil {
+		t.Fatalf("cannot decode problem: %v", err)
+	}
+	if p.Status != 429 || p.Title != "Too Many Requests" {
+		t.Errorf("problem = %+v", p)
+	}
+
+	templates := t.TempDir()
+	writeFile(t, filepath.Join(templates, "500.gohtml"), `{{ .Missing.Field }}`)
+	viper.Set("http.content.useEmbedded", false)
+	viper.Set("http.content.templatesDirectory", templates)
+	ErrorPages[500] = &ErrorPageDefinition{Name: "500.gohtml", IsTemplate: true}
+
+	rec = httptest.NewRecorder()
+	r.Header.Set("Accept", "text/html")
+	writeError(rec, r, model.ProcessingError{ResponseCode: 500})
+	if got := rec.Header().Get("Content-Type"); rec.Code != 500 || got != contentTypeHTML {
+		t.Errorf("broken error page: status %d, Content-Type %q, want 500, %s", rec.Code, got, contentTypeHTML)
+	}
+	if !strings.Contains(rec.Body.String(), "<h1>500 Internal Server Error</h1>") {
+		t.Errorf("broken error page: body = %q, want the status text", rec.Body.String())
+	}
+}
@@ -0,0 +1,224 @@
+package pepper
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/iktech/pepper/model"
+	"github.com/spf13/viper"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Export renders the whole site into the directory so that it can be served
+// by a static file host. Every route is rendered to path/index.html, static
+// files are copied together with their fingerprinted copies and the asset
+// manifest, error pages are written to 404.html and similar, and redirects
+// are written to a _redirects file. All routes are rendered even if some
+// fail; the returned error lists every failure.
+func Export(directory string) error {
+	if err := os.MkdirAll(directory, 0o755); err != nil {
+		return fmt.Errorf("cannot create export directory: %w", err)
+	}
+
+	var (
+		errs      []error
+		redirects []string
+		written   = make(map[string]bool)
+	)
+
+	keys := make([]string, 0, len(site.routerMap))
+	for key := range site.routerMap {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if site.protected(key) {
+			slog.Warn("protected route is not exported", "route", "/"+key, KeyComponent, ComponentExport)
+			continue
+		}
+		rec := exportRequest(key)
+		switch {
+		case rec.Code >= 300 && rec.Code < 400:
+			redirects = append(redirects, fmt.Sprintf("/%s %s %d", key, rec.Header().Get("Location"), rec.Code))
+		case rec.Code != http.StatusOK:
+			errs = append(errs, fmt.Errorf("cannot render /%s: status %d", key, rec.Code))
+		default:
+			name := exportFileName(key)
+			if err := writeExportFile(directory, name, rec.Body.Bytes()); err != nil {
+				errs = append(errs, err)
+			}
+			written[name] = true
+		}
+	}
+
+	static := staticRoot()
+	err := fs.WalkDir(static, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		if written[name] {
+			slog.Warn("static file is shadowed by a route", "file", name, KeyComponent, ComponentExport)
+			return nil
+		}
+		if site.protected(name) {
+			slog.Warn("protected static file is not exported", "file", name, KeyComponent, ComponentExport)
+			return nil
+		}
+		if err := copyExportFile(static, directory, name, name); err != nil {
+			errs = append(errs, err)
+		}
+		written[name] = true
+		return nil
+	})
+	if err != nil {
+		errs = append(errs, fmt.Errorf("cannot copy static files: %w", err))
+	}
+
+	for _, mount := range site.mounts {
+		if site.protected(mount.prefix) {
+			slog.Warn("protected static mount is not exported", "prefix", "/"+mount.prefix, KeyComponent, ComponentExport)
+			continue
+		}
+		root := mount.static.root
+		err := fs.WalkDir(root, ".", func(name string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return err
+			}
+			target := path.Join(mount.prefix, name)
+			if site.protected(target) {
+				slog.Warn("protected static file is not exported", "file", target, KeyComponent, ComponentExport)
+				return nil
+			}
+			if !written[target] {
+				if err := copyExportFile(root, directory, name, target); err != nil {
+					errs = append(errs, err)
+				}
+				written[target] = true
+			}
+			return nil
+		})
+		if err != nil {
+			errs = append(errs, fmt.Errorf("cannot copy static files of /%s: %w", mount.prefix, err))
+		}
+	}
+
+	if manifest := site.staticHandler.manifest(); len(manifest) > 0 {
+		for name, hashed := range manifest {
+			if err := copyExportFile(static, directory, name, hashed); err != nil {
+				errs = append(errs, err)
+			}
+		}
+
+		name := strings.TrimPrefix(viper.GetString("http.assets.manifest"), "/")
+		if name == "" {
+			name = "asset-manifest.json"
+		}
+		if !written[name] {
+			b, _ := json.MarshalIndent(manifest, "", "  ")
+			if err := writeExportFile(directory, name, b); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+
+	for code := range ErrorPages {
+		b, err := GetErrorPageContent(model.ProcessingError{ResponseCode: code})
+		if err != nil {
+			errs = append(errs, fmt.Errorf("cannot render error page %d: %w", code, err))
+			continue
+		}
+		if err := writeExportFile(directory, fmt.Sprintf("%d.html", code), b); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	for from, redirect := range site.redirects {
+		redirects = append(redirects, fmt.Sprintf("/%s %s %d", strings.TrimPrefix(from, "/"), redirect.Location, redirect.Code))
+	}
+	if len(redirects) > 0 {
+		sort.Strings(redirects)
+		if err := writeExportFile(directory, "_redirects", []byte(strings.Join(redirects, "\n")+"\n")); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+
+	slog.Info("exported site", "directory", directory, "files", len(written), KeyComponent, ComponentExport)
+	return nil
+}
+
+func exportRequest(key string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, "/"+key, nil)
+	r.Header.Set("Accept", "text/html")
+	if base := viper.GetString("http.site.baseUrl"); base != "" {
+		r.Host = strings.TrimPrefix(strings.TrimPrefix(strings.TrimSuffix(base, "/"), "https://"), "http://")
+	}
+
+	rec := httptest.NewRecorder()
+	site.ServeHTTP(rec, r)
+	return rec
+}
+
+// exportFileName maps a route to the file it is exported to: pages become
+// index.html files in a directory named after the route, other documents
+// such as feeds keep their name.
+func exportFileName(key string) string {
+	if key == "" {
+		return "index.html"
+	}
+	if path.Ext(key) == "" {
+		return path.Join(key, "index.html")
+	}
+	return key
+}
+
+func writeExportFile(directory, name string, b []byte) error {
+	target := filepath.Join(directory, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		return fmt.Errorf("cannot create directory for %s: %w", name, err)
+	}
+	if err := os.WriteFile(target, b, 0o644); err != nil {
+		return fmt.Errorf("cannot write %s: %w", name, err)
+	}
+	slog.Debug("exported file", "file", name, KeyComponent, ComponentExport)
+	return nil
+}
+
+func copyExportFile(fsys fs.FS, directory, name, targetName string) error {
+	src, err := fsys.Open(name)
+	if err != nil {
+		return fmt.Errorf("cannot open %s: %w", name, err)
+	}
+	defer src.Close()
+
+	target := filepath.Join(directory, filepath.FromSlash(targetName))
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		return fmt.Errorf("cannot create directory for %s: %w", targetName, err)
+	}
+	dst, err := os.Create(target)
+	if err != nil {
+		return fmt.Errorf("cannot create %s: %w", targetName, err)
+	}
+	if _, err = io.Copy(dst, src); err != nil {
+		_ = dst.Close()
+		return fmt.Errorf("cannot copy %s: %w", name, err)
+	}
+	slog.Debug("exported file", "file", targetName, KeyComponent, ComponentExport)
+	return dst.Close()
+}
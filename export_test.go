@@ -0,0 +1,79 @@
+package pepper
+
+import (
+	"embed"
+	"github.com/iktech/pepper/authentication"
+	"github.com/iktech/pepper/controllers"
+	"github.com/spf13/viper"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestExport(t *testing.T) {
+	t.Cleanup(viper.Reset)
+	previousSite, previousErrorPages, previousScopes := site, ErrorPages, errorPageScopes
+	previousHandlers := passwordHandlers
+	passwordHandlers = make(map[string]*authentication.BasicAuthHandler)
+	t.Cleanup(func() {
+		site, ErrorPages, errorPageScopes = previousSite, previousErrorPages, previousScopes
+		passwordHandlers = previousHandlers
+	})
+
+	templates, static := t.TempDir(), t.TempDir()
+	writeFile(t, filepath.Join(templates, "index.gohtml"), "home")
+	writeFile(t, filepath.Join(templates, "about.gohtml"), "about {{ .Path }}")
+	writeFile(t, filepath.Join(templates, "admin.gohtml"), "secret")
+	writeFile(t, filepath.Join(static, "css", "site.css"), "body {}")
+	writeFile(t, filepath.Join(static, "missing.html"), "not found")
+
+	viper.Set("http.content.useEmbedded", false)
+	viper.Set("http.content.templatesDirectory", templates)
+	viper.Set("http.content.staticDirectory", static)
+	viper.Set("http.password.file", filepath.Join(t.TempDir(), "missing"))
+	viper.Set("http.sitemap.enabled", false)
+	viper.Set("http.assets.fingerprint", false)
+	viper.Set("http.controllers", map[string]interface{}{"": "index.gohtml", "about": "about.gohtml", "admin": "admin.gohtml"})
+	viper.Set("http.redirects", map[string]interface{}{
+		"old":   map[string]interface{}{"location": "/about"},
+		"moved": map[string]interface{}{"location": "https://example.com/", "code": 302},
+	})
+	viper.Set("http.errorPages", map[string]interface{}{"404": "missing.html"})
+	viper.Set("http.auth.rules", []map[string]interface{}{{"name": "admin", "prefix": "admin"}})
+
+	CreateExport(embed.FS{}, embed.FS{}, func(routerMap map[string]controllers.Controller) map[string]controllers.Controller {
+		return routerMap
+	})
+	if len(passwordHandlers) != 0 {
+		t.Errorf("%d password files loaded for the export", len(passwordHandlers))
+	}
+
+	directory := t.TempDir()
+	if err := Export(directory); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"index.html", "home"},
+		{"about/index.html", "about about"},
+		{"css/site.css", "body {}"},
+		{"404.html", "not found"},
+		{"_redirects", "/moved https://example.com/ 302\n/old /about 301\n"},
+	}
+	for _, tt := range tests {
+		b, err := os.ReadFile(filepath.Join(directory, filepath.FromSlash(tt.name)))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if string(b) != tt.want {
+			t.Errorf("%s = %q, want %q", tt.name, b, tt.want)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(directory, "admin")); !os.IsNotExist(err) {
+		t.Errorf("protected route exported: %v", err)
+	}
+}
@@ -0,0 +1,55 @@
+package identity
+
+import (
+	"context"
+	"slices"
+	"sync/atomic"
+)
+
+type key int
+
+const (
+	principalKey key = iota
+	recorderKey
+)
+
+// Principal is the authenticated user of a request.
+type Principal struct {
+	User   string
+	Scheme string
+	Realm  string
+	// Roles are the roles of the user, as given by http.auth.roles.
+	Roles []string
+}
+
+// HasRole reports whether the principal has the role. It is false for a nil
+// principal, so templates can call it for anonymous requests.
+func (p *Principal) HasRole(role string) bool {
+	return p != nil && slices.Contains(p.Roles, role)
+}
+
+// WithPrincipal returns the context carrying the principal. Every
+// authentication mechanism sets the principal with it, which also records
+// the principal for the access log.
+func WithPrincipal(ctx context.Context, p *Principal) context.Context {
+	if recorder, ok := ctx.Value(recorderKey).(*atomic.Pointer[Principal]); ok {
+		recorder.Store(p)
+	}
+	return context.WithValue(ctx, principalKey, p)
+}
+
+// PrincipalFrom returns the principal of the request context, or nil if the
+// request is not authenticated.
+func PrincipalFrom(ctx context.Context) *Principal {
+	p, _ := ctx.Value(principalKey).(*Principal)
+	return p
+}
+
+// RecordPrincipal returns a context in which the principal set by handlers
+// further down the chain is recorded, and a function returning it once they
+// are done. Middleware running before authentication, such as the access
+// log, uses it since it cannot see the contexts derived by later handlers.
+func RecordPrincipal(ctx context.Context) (context.Context, func() *Principal) {
+	recorder := &atomic.Pointer[Principal]{}
+	return context.WithValue(ctx, recorderKey, recorder), recorder.Load
+}
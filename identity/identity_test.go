@@ -0,0 +1,43 @@
+package identity
+
+import (
+	"context"
+	"testing"
+)
+
+func TestRecordPrincipal(t *testing.T) {
+	ctx, recorded := RecordPrincipal(context.Background())
+	if p := recorded(); p != nil {
+		t.Fatalf("principal %v recorded before authentication", p)
+	}
+
+	alice := &Principal{User: "alice", Scheme: "basic", Roles: []string{"editor"}}
+	authenticated := WithPrincipal(ctx, alice)
+	if p := PrincipalFrom(authenticated); p != alice {
+		t.Errorf("PrincipalFrom() = %v, want %v", p, alice)
+	}
+	if p := PrincipalFrom(ctx); p != nil {
+		t.Errorf("principal %v in the context before authentication", p)
+	}
+	if p := recorded(); p != alice {
+		t.Errorf("recorded principal = %v, want %v", p, alice)
+	}
+}
+
+func TestWithPrincipalWithoutRecorder(t *testing.T) {
+	alice := &Principal{User: "alice"}
+	if p := PrincipalFrom(WithPrincipal(context.Background(), alice)); p != alice {
+		t.Errorf("PrincipalFrom() = %v, want %v", p, alice)
+	}
+}
+
+func TestHasRole(t *testing.T) {
+	var anonymous *Principal
+	if anonymous.HasRole("editor") {
+		t.Error("anonymous principal has a role")
+	}
+	p := &Principal{User: "alice", Roles: []string{"admin", "editor"}}
+	if !p.HasRole("editor") || p.HasRole("viewer") {
+		t.Errorf("HasRole() wrong for roles %q", p.Roles)
+	}
+}
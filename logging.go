@@ -1,7 +1,10 @@
 package pepper
 
 import (
+	"github.com/iktech/pepper/identity"
+	"github.com/spf13/viper"
 	"log/slog"
+	"net"
 	"net/http"
 	"strconv"
 	"strings"
@@ -16,6 +19,7 @@ type loggingResponseWriter struct {
 	http.ResponseWriter
 	statusCode          int
 	size                int
+	uncompressedSize    int
 	processingStartTime time.Time
 	duration            float64
 }
@@ -24,7 +28,8 @@ func Logging() func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			lrw := NewLoggingResponseWriter(w)
-			next.ServeHTTP(lrw, r)
+			ctx, principal := identity.RecordPrincipal(r.Context())
+			next.ServeHTTP(lrw, r.WithContext(ctx))
 			defer func() {
 				requestID, ok := r.Context().Value(requestIDKey).(string)
 				if !ok {
@@ -39,8 +44,17 @@ func Logging() func(http.Handler) http.Handler {
 					}
 				}
 
+				user := ""
+				if p := principal(); p != nil {
+					user = p.User
+				}
+
 				if r.URL.Path != "/ready" && r.URL.Path != "/healthz" && r.URL.Path != "/metrics" {
-					slog.Info("http server request", "ip_address", ip, "request_id", requestID, "method", r.Method, "status", lrw.statusCode, "path", r.URL.RequestURI(), "processing_time", lrw.duration, "size", lrw.size, "user_agent", r.UserAgent(), KeyComponent, ComponentAccessLog)
+					uncompressedSize := lrw.uncompressedSize
+					if uncompressedSize == 0 {
+						uncompressedSize = lrw.size
+					}
+					slog.Info("http server request", "ip_address", ip, "request_id", requestID, "user", user, "method", r.Method, "status", lrw.statusCode, "path", r.URL.RequestURI(), "processing_time", lrw.duration, "size", lrw.size, "uncompressed_size", uncompressedSize, "user_agent", r.UserAgent(), KeyComponent, ComponentAccessLog)
 					RequestDurationGauge.WithLabelValues(strconv.Itoa(lrw.statusCode), r.Method, r.URL.Path).Set(lrw.duration)
 					RequestDurationSummary.WithLabelValues(strconv.Itoa(lrw.statusCode), r.Method, r.URL.Path).Observe(lrw.duration)
 				}
@@ -49,8 +63,25 @@ func Logging() func(http.Handler) http.Handler {
 	}
 }
 
+// clientIP returns the address of the client. The X-Forwarded-For header is
+// only used if http.trustForwardedFor is set, as it can be forged by clients
+// connecting directly.
+func clientIP(r *http.Request) string {
+	if viper.GetBool("http.trustForwardedFor") {
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			first, _, _ := strings.Cut(forwarded, ",")
+			return strings.TrimSpace(first)
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
 func NewLoggingResponseWriter(w http.ResponseWriter) *loggingResponseWriter {
-	return &loggingResponseWriter{w, http.StatusOK, 0, time.Now(), 0}
+	return &loggingResponseWriter{w, http.StatusOK, 0, 0, time.Now(), 0}
 }
 
 func (lrw *loggingResponseWriter) WriteHeader(code int) {
@@ -60,9 +91,15 @@ func (lrw *loggingResponseWriter) WriteHeader(code int) {
 
 func (lrw *loggingResponseWriter) Write(body []byte) (int, error) {
 	if body != nil {
-		lrw.size = len(body)
+		lrw.size += len(body)
 	}
 	code, err := lrw.ResponseWriter.Write(body)
 	lrw.duration = time.Now().Sub(lrw.processingStartTime).Seconds()
 	return code, err
 }
+
+func (lrw *loggingResponseWriter) Flush() {
+	if f, ok := lrw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
@@ -0,0 +1,191 @@
+package pepper
+
+import (
+	"encoding/json"
+	"github.com/iktech/pepper/model"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/spf13/viper"
+	"log/slog"
+	"net/http"
+	"net/netip"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// maintenanceSwitch is the maintenance state set through the admin endpoint.
+var maintenanceSwitch atomic.Bool
+
+// maintenanceChecked holds the state of the configuration and the flag file
+// until it expires, so that requests and metric scrapes do not stat the file.
+var maintenanceChecked atomic.Pointer[maintenanceCheck]
+
+type maintenanceCheck struct {
+	config  bool
+	file    bool
+	expires time.Time
+}
+
+var MaintenanceGauge = prometheus.NewGaugeFunc(
+	prometheus.GaugeOpts{
+		Name: "http_router_maintenance",
+		Help: "Whether the site is in maintenance mode",
+	},
+	func() float64 {
+		if inMaintenance() {
+			return 1
+		}
+		return 0
+	},
+)
+
+// maintenanceState reports the sources of maintenance mode.
+type maintenanceState struct {
+	Maintenance bool `json:"maintenance"`
+	Config      bool `json:"config"`
+	File        bool `json:"file"`
+	Admin       bool `json:"admin"`
+}
+
+// Maintenance answers all requests with the 503 error page and a Retry-After
+// header while the site is in maintenance. The site is in maintenance if
+// http.maintenance.enabled is set, if the file http.maintenance.file exists
+// or if it has been switched on through the admin endpoint. The setting and
+// the file are checked again after http.maintenance.checkInterval. Requests from
+// the allowed addresses, for the excluded paths such as health checks and
+// for static assets other than HTML documents are served as usual.
+func Maintenance() func(http.Handler) http.Handler {
+	allowed := maintenanceAllowList()
+	exclude := viper.GetStringSlice("http.maintenance.exclude")
+	retryAfter := viper.GetString("http.maintenance.retryAfter")
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !inMaintenance() || maintenanceExempt(r, allowed, exclude) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if retryAfter != "" {
+				w.Header().Set("Retry-After", retryAfter)
+			}
+			w.Header().Set("Cache-Control", "no-store")
+			writeError(w, r, model.ProcessingError{ResponseCode: http.StatusServiceUnavailable})
+		})
+	}
+}
+
+func currentMaintenanceState() maintenanceState {
+	now := time.Now()
+	check := maintenanceChecked.Load()
+	if check == nil || !now.Before(check.expires) {
+		check = &maintenanceCheck{
+			config:  viper.GetBool("http.maintenance.enabled"),
+			expires: now.Add(viper.GetDuration("http.maintenance.checkInterval")),
+		}
+		if file := viper.GetString("http.maintenance.file"); file != "" {
+			_, err := os.Stat(file)
+			check.file = err == nil
+		}
+		maintenanceChecked.Store(check)
+	}
+
+	state := maintenanceState{
+		Config: check.config,
+		File:   check.file,
+		Admin:  maintenanceSwitch.Load(),
+	}
+	state.Maintenance = state.Config || state.File || state.Admin
+	return state
+}
+
+func inMaintenance() bool {
+	return currentMaintenanceState().Maintenance
+}
+
+func maintenanceExempt(r *http.Request, allowed []netip.Prefix, exclude []string) bool {
+	name := strings.TrimPrefix(r.URL.Path, "/")
+	for _, pattern := range exclude {
+		if matchPattern(pattern, name) {
+			return true
+		}
+	}
+
+	if _, found := site.routerMap[name]; !found && isAsset(name) {
+		if _, mounted := site.mount(name); mounted || site.staticHandler.serves(name) {
+			return true
+		}
+	}
+
+	if ip, err := netip.ParseAddr(clientIP(r)); err == nil {
+		for _, prefix := range allowed {
+			if prefix.Contains(ip.Unmap()) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isAsset reports whether the path refers to a file other than an HTML
+// document, such as a style sheet or an image.
+func isAsset(name string) bool {
+	ext := strings.ToLower(path.Ext(name))
+	return ext != "" && ext != ".html" && ext != ".htm"
+}
+
+// maintenanceAllowList parses http.maintenance.allow, a list of addresses
+// and networks in CIDR notation.
+func maintenanceAllowList() []netip.Prefix {
+	return allowList("http.maintenance.allow")
+}
+
+// allowList parses the list of addresses and networks in CIDR notation at
+// the configuration key.
+func allowList(key string) []netip.Prefix {
+	var allowed []netip.Prefix
+	for _, entry := range viper.GetStringSlice(key) {
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			addr, addrErr := netip.ParseAddr(entry)
+			if addrErr != nil {
+				slog.Error("unexpected address in allow list", "key", key, "address", entry, KeyError, err, KeyComponent, ComponentService)
+				os.Exit(1)
+			}
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		allowed = append(allowed, prefix)
+	}
+	return allowed
+}
+
+// maintenanceEndpoint reports the maintenance state on GET, switches
+// maintenance on with POST or PUT and off with DELETE. Switching off only
+// affects the admin switch; the configuration and the flag file still apply.
+// The endpoint is only served if its path is set in
+// http.maintenance.endpoint, for example "/admin/maintenance", and requires
+// the credentials of the password file like /metrics.
+func maintenanceEndpoint(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+	case http.MethodPost, http.MethodPut:
+		maintenanceSwitch.Store(true)
+		slog.Warn("maintenance mode switched on", "ip_address", clientIP(r), KeyComponent, ComponentService)
+	case http.MethodDelete:
+		maintenanceSwitch.Store(false)
+		slog.Warn("maintenance mode switched off", "ip_address", clientIP(r), KeyComponent, ComponentService)
+	default:
+		w.Header().Set("Allow", "GET, HEAD, POST, PUT, DELETE")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	b, _ := json.Marshal(currentMaintenanceState())
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", strconv.Itoa(len(b)))
+	w.Header().Set("Cache-Control", "no-store")
+	_, _ = w.Write(b)
+}
@@ -0,0 +1,173 @@
+package pepper
+
+import (
+	"encoding/json"
+	"github.com/spf13/viper"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+)
+
+// maintenanceService sets up a site with a page and a style sheet and
+// returns it behind the Maintenance middleware, once the configuration has
+// been applied by configure.
+func maintenanceService(t *testing.T, configure func()) http.Handler {
+	t.Helper()
+	t.Cleanup(viper.Reset)
+	previousSite, previousPages := site, ErrorPages
+	t.Cleanup(func() {
+		site, ErrorPages = previousSite, previousPages
+		maintenanceChecked.Store(nil)
+		maintenanceSwitch.Store(false)
+	})
+	maintenanceChecked.Store(nil)
+	maintenanceSwitch.Store(false)
+
+	ErrorPages = map[int]*ErrorPageDefinition{503: {Name: "503.html", IsDefault: true}}
+	site = Service{staticHandler: newStaticHandler(fstest.MapFS{
+		"index.html":   {Data: []byte("home")},
+		"css/site.css": {Data: []byte("body{}")},
+	}, nil, true)}
+
+	viper.Set("http.maintenance.retryAfter", "300")
+	viper.Set("http.maintenance.exclude", []string{"healthz", "ready"})
+	configure()
+	return Maintenance()(site)
+}
+
+func TestMaintenance(t *testing.T) {
+	tests := []struct {
+		name      string
+		path      string
+		remote    string
+		forwarded string
+		trusted   bool
+		code      int
+	}{
+		{name: "page", path: "/", code: http.StatusServiceUnavailable},
+		{name: "excluded path", path: "/healthz", code: http.StatusNotFound},
+		{name: "existing asset", path: "/css/site.css", code: http.StatusOK},
+		{name: "missing asset", path: "/css/missing.css", code: http.StatusServiceUnavailable},
+		{name: "HTML document", path: "/index.html", code: http.StatusServiceUnavailable},
+		{name: "allowed address", path: "/", remote: "10.1.2.3:4567", code: http.StatusOK},
+		{name: "allowed address mapped to IPv6", path: "/", remote: "[::ffff:10.1.2.3]:4567", code: http.StatusOK},
+		{name: "forwarded without trust", path: "/", forwarded: "10.1.2.3", code: http.StatusServiceUnavailable},
+		{name: "trusted forwarded address", path: "/", forwarded: "10.1.2.3, 192.0.2.1", trusted: true, code: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := maintenanceService(t, func() {
+				viper.Set("http.maintenance.enabled", true)
+				viper.Set("http.maintenance.allow", []string{"10.0.0.0/8"})
+				viper.Set("http.trustForwardedFor", tt.trusted)
+			})
+
+			r := httptest.NewRequest("GET", tt.path, nil)
+			r.Header.Set("Accept", "text/html")
+			if tt.remote != "" {
+				r.RemoteAddr = tt.remote
+			}
+			if tt.forwarded != "" {
+				r.Header.Set("X-Forwarded-For", tt.forwarded)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+
+			if w.Code != tt.code {
+				t.Fatalf("status %d, want %d", w.Code, tt.code)
+			}
+			if tt.code != http.StatusServiceUnavailable {
+				return
+			}
+			if got := w.Header().Get("Retry-After"); got != "300" {
+				t.Errorf("Retry-After = %q, want 300", got)
+			}
+			if got := w.Header().Get("Cache-Control"); got != "no-store" {
+				t.Errorf("Cache-Control = %q, want no-store", got)
+			}
+		})
+	}
+}
+
+func TestMaintenanceFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "maintenance")
+	h := maintenanceService(t, func() {
+		viper.Set("http.maintenance.file", file)
+		viper.Set("http.maintenance.checkInterval", "1h")
+	})
+	status := func() int {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+		return w.Code
+	}
+
+	if code := status(); code != http.StatusOK {
+		t.Fatalf("status without the flag file %d, want %d", code, http.StatusOK)
+	}
+	writeFile(t, file, "")
+	if code := status(); code != http.StatusOK {
+		t.Errorf("status before the check interval elapsed %d, want %d", code, http.StatusOK)
+	}
+
+	maintenanceChecked.Store(nil)
+	if code := status(); code != http.StatusServiceUnavailable {
+		t.Errorf("status with the flag file %d, want %d", code, http.StatusServiceUnavailable)
+	}
+	if !currentMaintenanceState().File {
+		t.Error("flag file not reported")
+	}
+
+	if err := os.Remove(file); err != nil {
+		t.Fatal(err)
+	}
+	viper.Set("http.maintenance.checkInterval", 0)
+	maintenanceChecked.Store(nil)
+	if code := status(); code != http.StatusOK {
+		t.Errorf("status after removing the flag file %d, want %d", code, http.StatusOK)
+	}
+}
+
+func TestMaintenanceEndpoint(t *testing.T) {
+	h := maintenanceService(t, func() {})
+
+	tests := []struct {
+		method      string
+		code        int
+		maintenance bool
+		status      int
+	}{
+		{http.MethodGet, http.StatusOK, false, http.StatusOK},
+		{http.MethodPost, http.StatusOK, true, http.StatusServiceUnavailable},
+		{http.MethodGet, http.StatusOK, true, http.StatusServiceUnavailable},
+		{http.MethodDelete, http.StatusOK, false, http.StatusOK},
+		{http.MethodPut, http.StatusOK, true, http.StatusServiceUnavailable},
+		{http.MethodPatch, http.StatusMethodNotAllowed, true, http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		maintenanceEndpoint(w, httptest.NewRequest(tt.method, "/admin/maintenance", nil))
+		if w.Code != tt.code {
+			t.Errorf("%s: status %d, want %d", tt.method, w.Code, tt.code)
+		}
+		if tt.code == http.StatusOK {
+			var state maintenanceState
+			if err := json.Unmarshal(w.Body.Bytes(), &state); err != nil {
+				t.Fatalf("%s: %v", tt.method, err)
+			}
+			if state.Maintenance != tt.maintenance || state.Admin != tt.maintenance || state.Config || state.File {
+				t.Errorf("%s: state = %+v, want maintenance %v from the admin switch", tt.method, state, tt.maintenance)
+			}
+		} else if got := w.Header().Get("Allow"); got != "GET, HEAD, POST, PUT, DELETE" {
+			t.Errorf("%s: Allow = %q", tt.method, got)
+		}
+
+		w = httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+		if w.Code != tt.status {
+			t.Errorf("after %s: page status %d, want %d", tt.method, w.Code, tt.status)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package model
+
+import (
+	"bufio"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+)
+
+// excerptContext is the number of lines shown before and after the line of
+// a template error.
+const excerptContext = 3
+
+var templateErrorLocation = regexp.MustCompile(`(?:html/)?template: ?([^:\s]+):(\d+)(?::(\d+))?:`)
+
+// TemplateError describes a template which could not be parsed or executed,
+// with the location of the error and the data the template was given. The
+// includes are the other templates parsed with it or, if parsing failed,
+// the files matching the include patterns.
+type TemplateError struct {
+	Err      error
+	Phase    string
+	Template string
+	File     string
+	Line     int
+	Column   int
+	Excerpt  []ExcerptLine
+	Includes []string
+	Data     interface{}
+}
+
+// ExcerptLine is a line of the template around the location of an error.
+type ExcerptLine struct {
+	Number  int
+	Text    string
+	IsError bool
+}
+
+func (e *TemplateError) Error() string {
+	return fmt.Sprintf("cannot %s template %s: %v", e.Phase, e.Template, e.Err)
+}
+
+func (e *TemplateError) Unwrap() error {
+	return e.Err
+}
+
+// newTemplateError locates the error in the templates of the model, reading
+// an excerpt of the template file around the line reported by the template
+// package.
+func (m Model) newTemplateError(phase string, err error, data interface{}, includes []string) *TemplateError {
+	e := &TemplateError{
+		Err:      err,
+		Phase:    phase,
+		Template: m.Template,
+		Includes: includes,
+		Data:     data,
+	}
+
+	match := templateErrorLocation.FindStringSubmatch(err.Error())
+	if match == nil || m.TemplatesDirectory == nil {
+		return e
+	}
+	e.Line, _ = strconv.Atoi(match[2])
+	e.Column, _ = strconv.Atoi(match[3])
+	e.File = m.templateFile(match[1])
+	if e.File != "" {
+		e.Excerpt = excerpt(m.TemplatesDirectory, e.File, e.Line)
+	}
+	return e
+}
+
+// templateFile returns the file defining the template, which is named after
+// the base name of its file.
+func (m Model) templateFile(name string) string {
+	for _, pattern := range append([]string{m.Template}, m.Includes...) {
+		files, err := fs.Glob(m.TemplatesDirectory, pattern)
+		if err != nil {
+			continue
+		}
+		for _, file := range files {
+			if path.Base(file) == name {
+				return file
+			}
+		}
+	}
+	return ""
+}
+
+// parsedTemplates returns the names of the templates defined along with the
+// template, sorted.
+func parsedTemplates(t *template.Template) []string {
+	var names []string
+	for _, defined := range t.Templates() {
+		if defined.Name() != t.Name() {
+			names = append(names, defined.Name())
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// includedFiles returns the files matching the include patterns of the
+// model.
+func (m Model) includedFiles() []string {
+	var files []string
+	for _, pattern := range m.Includes {
+		matches, err := fs.Glob(m.TemplatesDirectory, pattern)
+		if err != nil {
+			continue
+		}
+		files = append(files, matches...)
+	}
+	return files
+}
+
+func excerpt(fsys fs.FS, name string, line int) []ExcerptLine {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	var lines []ExcerptLine
+	scanner := bufio.NewScanner(f)
+	for number := 1; scanner.Scan() && number <= line+excerptContext; number++ {
+		if number >= line-excerptContext {
+			lines = append(lines, ExcerptLine{Number: number, Text: scanner.Text(), IsError: number == line})
+		}
+	}
+	return lines
+}
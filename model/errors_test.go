@@ -0,0 +1,46 @@
+package model
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"testing/fstest"
+)
+
+func TestTemplateErrorIncludes(t *testing.T) {
+	fsys := fstest.MapFS{
+		"page.gohtml":            {Data: []byte(`{{ template "header" . }}{{ index .Title 10 }}`)},
+		"broken.gohtml":          {Data: []byte(`{{ if }}`)},
+		"partials/header.gohtml": {Data: []byte(`{{ define "header" }}<h1>{{ .Title }}</h1>{{ end }}`)},
+		"partials/footer.gohtml": {Data: []byte(`{{ define "footer" }}<footer></footer>{{ end }}`)},
+		"partials/unused.txt":    {Data: []byte(`not a template`)},
+		"layouts/default.gohtml": {Data: []byte(`{{ define "layout" }}{{ end }}`)},
+	}
+	includes := []string{"partials/*.gohtml", "layouts/*.gohtml"}
+
+	tests := []struct {
+		template string
+		phase    string
+		want     []string
+	}{
+		{"page.gohtml", "execute", []string{"default.gohtml", "footer", "footer.gohtml", "header", "header.gohtml", "layout"}},
+		{"broken.gohtml", "parse", []string{"partials/footer.gohtml", "partials/header.gohtml", "layouts/default.gohtml"}},
+	}
+	for _, tt := range tests {
+		m := Model{Template: tt.template, TemplatesDirectory: fsys, Includes: includes}
+		_, _, _, _, pe := m.Render(false, map[string]string{"Title": "x"})
+		if pe == nil {
+			t.Fatalf("%s: no error", tt.template)
+		}
+		var te *TemplateError
+		if !errors.As(pe.Err, &te) {
+			t.Fatalf("%s: error %v is not a template error", tt.template, pe.Err)
+		}
+		if te.Phase != tt.phase {
+			t.Errorf("%s: phase = %s, want %s", tt.template, te.Phase, tt.phase)
+		}
+		if !reflect.DeepEqual(te.Includes, tt.want) {
+			t.Errorf("%s: includes = %q, want %q", tt.template, te.Includes, tt.want)
+		}
+	}
+}
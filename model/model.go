@@ -2,10 +2,13 @@ package model
 
 import (
 	"bytes"
+	"github.com/iktech/pepper/identity"
 	"html/template"
 	"io/fs"
 	"log/slog"
+	"net/http"
 	"reflect"
+	"time"
 )
 
 const (
@@ -22,11 +25,22 @@ type Model struct {
 	ResponseCode       int
 	ContentType        string
 	GoogleAnalyticsId  string
+	Robots             string
 }
 
+// Functions is the function map shared by all templates rendered by pepper.
+// Packages providing template helpers add their functions to it on
+// initialisation, before any template is parsed. The principal function
+// returns the authenticated user of the request, or nil if there is none.
+// Templates also rendered for anonymous requests have to check for nil, as
+// in {{ with principal }}{{ .User }}{{ end }}: {{ principal.User }} fails
+// with a 500 on public pages. {{ principal.HasRole "editor" }} is safe.
+var Functions = template.FuncMap{"isset": IsSet, "principal": noPrincipal}
+
 type ProcessingError struct {
 	ResponseCode int
 	Data         interface{}
+	Err          error
 }
 
 func (m Model) IsActive(path string) string {
@@ -37,6 +51,24 @@ func (m Model) IsActive(path string) string {
 	return "link"
 }
 
+// LastModified returns the modification time of the page template, which is
+// zero for embedded templates.
+func (m Model) LastModified() time.Time {
+	if m.TemplatesDirectory == nil || m.Template == "" {
+		return time.Time{}
+	}
+	info, err := fs.Stat(m.TemplatesDirectory, m.Template)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
+
+// RobotsTag returns the robots directives of the page, such as "noindex".
+func (m Model) RobotsTag() string {
+	return m.Robots
+}
+
 func IsSet(name string, data interface{}) bool {
 	v := reflect.ValueOf(data)
 	if v.Kind() == reflect.Ptr {
@@ -48,18 +80,34 @@ func IsSet(name string, data interface{}) bool {
 	return v.FieldByName(name).IsValid()
 }
 
+func noPrincipal() *identity.Principal {
+	return nil
+}
+
 func (m Model) Render(Debug bool, data interface{}) (int, string, string, *bytes.Buffer, *ProcessingError) {
+	return m.RenderRequest(Debug, nil, data)
+}
+
+// RenderRequest renders the template like Render, for the request so that
+// templates can read its principal.
+func (m Model) RenderRequest(Debug bool, r *http.Request, data interface{}) (int, string, string, *bytes.Buffer, *ProcessingError) {
 	if Debug {
-		slog.Debug("using %s template", m.Template, KeyComponent, ComponentModel)
+		slog.Debug("using template", "template", m.Template, KeyComponent, ComponentModel)
 	}
 
 	patterns := []string{m.Template}
 	patterns = append(patterns, m.Includes...)
 
-	t, err := template.New(m.Template).Funcs(template.FuncMap{"isset": IsSet}).ParseFS(m.TemplatesDirectory, patterns...)
+	t := template.New(m.Template).Funcs(Functions)
+	if r != nil {
+		t.Funcs(template.FuncMap{"principal": func() *identity.Principal {
+			return identity.PrincipalFrom(r.Context())
+		}})
+	}
+	t, err := t.ParseFS(m.TemplatesDirectory, patterns...)
 	if err != nil {
 		slog.Error("cannot create template", KeyError, err, KeyComponent, ComponentModel)
-		return 0, "", "", nil, &ProcessingError{ResponseCode: 500}
+		return 0, "", "", nil, &ProcessingError{ResponseCode: 500, Err: m.newTemplateError("parse", err, data, m.includedFiles())}
 	}
 
 	var buf bytes.Buffer
@@ -67,7 +115,7 @@ func (m Model) Render(Debug bool, data interface{}) (int, string, string, *bytes
 	err = t.Execute(&buf, &data)
 	if err != nil {
 		slog.Error("cannot render document from template", KeyError, err, KeyComponent, ComponentModel)
-		return 0, "", "", nil, &ProcessingError{ResponseCode: 500}
+		return 0, "", "", nil, &ProcessingError{ResponseCode: 500, Err: m.newTemplateError("execute", err, data, parsedTemplates(t))}
 	}
 
 	code := m.ResponseCode
@@ -0,0 +1,47 @@
+package model
+
+import (
+	"github.com/iktech/pepper/identity"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+)
+
+func TestPrincipalFunction(t *testing.T) {
+	fsys := fstest.MapFS{
+		"guarded.gohtml":   {Data: []byte(`{{ with principal }}{{ .User }}{{ else }}anonymous{{ end }} {{ principal.HasRole "editor" }}`)},
+		"unguarded.gohtml": {Data: []byte(`{{ principal.User }}`)},
+	}
+	anonymous := httptest.NewRequest("GET", "/", nil)
+	authenticated := httptest.NewRequest("GET", "/", nil)
+	authenticated = authenticated.WithContext(identity.WithPrincipal(authenticated.Context(), &identity.Principal{User: "alice", Roles: []string{"editor"}}))
+
+	tests := []struct {
+		name     string
+		template string
+		anon     bool
+		want     string
+		code     int
+	}{
+		{name: "guarded anonymous", template: "guarded.gohtml", anon: true, want: "anonymous false"},
+		{name: "guarded authenticated", template: "guarded.gohtml", want: "alice true"},
+		{name: "unguarded authenticated", template: "unguarded.gohtml", want: "alice"},
+		{name: "unguarded anonymous", template: "unguarded.gohtml", anon: true, code: 500},
+	}
+	for _, tt := range tests {
+		r := authenticated
+		if tt.anon {
+			r = anonymous
+		}
+		m := Model{Template: tt.template, TemplatesDirectory: fsys}
+		_, _, _, buf, pe := m.RenderRequest(false, r, nil)
+		switch {
+		case tt.code != 0 && (pe == nil || pe.ResponseCode != tt.code):
+			t.Errorf("%s: error %v, want status %d", tt.name, pe, tt.code)
+		case tt.code == 0 && pe != nil:
+			t.Errorf("%s: %v", tt.name, pe.Err)
+		case tt.code == 0 && buf.String() != tt.want:
+			t.Errorf("%s: page = %q, want %q", tt.name, buf.String(), tt.want)
+		}
+	}
+}
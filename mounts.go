@@ -0,0 +1,137 @@
+package pepper
+
+import (
+	"github.com/iktech/pepper/model"
+	"github.com/spf13/viper"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+)
+
+// StaticMount serves a directory on disk, or a directory of the embedded
+// static files, at a URL prefix. Every mount has its own cache rules,
+// listing and index policy, and may be restricted to an auth group.
+type StaticMount struct {
+	Prefix        string      `mapstructure:"prefix"`
+	Directory     string      `mapstructure:"directory"`
+	Embedded      string      `mapstructure:"embedded"`
+	Cache         []CacheRule `mapstructure:"cache"`
+	Listing       []string    `mapstructure:"listing"`
+	IndexFiles    []string    `mapstructure:"indexFiles"`
+	MissingIndex  int         `mapstructure:"missingIndex"`
+	Precompressed *bool       `mapstructure:"precompressed"`
+	Auth          string      `mapstructure:"auth"`
+}
+
+// staticMount is a configured mount with the handler serving it.
+type staticMount struct {
+	prefix    string
+	static    *staticFileHandler
+	handler   http.Handler
+	protected bool
+}
+
+// loadStaticMounts reads the mounts from http.static.mounts, longest prefix
+// first. Settings not given for a mount default to the ones of the root
+// static files.
+func loadStaticMounts() []staticMount {
+	var config []StaticMount
+	if err := viper.UnmarshalKey("http.static.mounts", &config); err != nil {
+		slog.Error("cannot read static mounts", KeyError, err, KeyComponent, ComponentService)
+		os.Exit(1)
+	}
+
+	mounts := make([]staticMount, 0, len(config))
+	for _, m := range config {
+		prefix := strings.Trim(m.Prefix, "/")
+		if prefix == "" {
+			slog.Error("static mount has no prefix", KeyComponent, ComponentService)
+			os.Exit(1)
+		}
+
+		var root fs.FS
+		switch {
+		case m.Directory != "" && m.Embedded != "":
+			slog.Error("static mount has both a directory and an embedded directory", "prefix", "/"+prefix, KeyComponent, ComponentService)
+			os.Exit(1)
+		case m.Directory != "":
+			root = os.DirFS(m.Directory)
+		case m.Embedded != "":
+			var err error
+			if root, err = fs.Sub(staticFiles, strings.Trim(m.Embedded, "/")); err != nil {
+				slog.Error("cannot read embedded directory of static mount", "prefix", "/"+prefix, KeyError, err, KeyComponent, ComponentService)
+				os.Exit(1)
+			}
+		default:
+			slog.Error("static mount has no directory", "prefix", "/"+prefix, KeyComponent, ComponentService)
+			os.Exit(1)
+		}
+
+		static := newStaticHandler(root, m.Cache, m.Embedded != "")
+		static.listing = m.Listing
+		static.indexFiles = m.IndexFiles
+		if len(static.indexFiles) == 0 {
+			static.indexFiles = viper.GetStringSlice("http.static.indexFiles")
+		}
+		static.missingIndex = m.MissingIndex
+		if static.missingIndex == 0 {
+			static.missingIndex = viper.GetInt("http.static.missingIndex")
+		}
+		static.precompressed = viper.GetBool("http.static.precompressed")
+		if m.Precompressed != nil {
+			static.precompressed = *m.Precompressed
+		}
+
+		mount := staticMount{prefix: prefix, static: static}
+		mount.handler = http.StripPrefix("/"+prefix, http.HandlerFunc(mount.serve))
+		if m.Auth != "" {
+			mount.handler = authGroup(m.Auth)(mount.handler)
+			mount.protected = true
+		}
+
+		slog.Info("mounted static files", "prefix", "/"+prefix, "directory", m.Directory, "embedded", m.Embedded, "auth", m.Auth, KeyComponent, ComponentService)
+		mounts = append(mounts, mount)
+	}
+
+	sort.SliceStable(mounts, func(i, j int) bool {
+		return len(mounts[i].prefix) > len(mounts[j].prefix)
+	})
+	return mounts
+}
+
+// mount returns the mount serving the path, if any.
+func (s Service) mount(name string) (staticMount, bool) {
+	for _, m := range s.mounts {
+		if name == m.prefix || strings.HasPrefix(name, m.prefix+"/") {
+			return m, true
+		}
+	}
+	return staticMount{}, false
+}
+
+func (m staticMount) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if strings.TrimPrefix(r.URL.Path, "/") == m.prefix {
+		http.Redirect(w, r, "/"+m.prefix+"/", http.StatusMovedPermanently)
+		return
+	}
+	m.handler.ServeHTTP(w, r)
+}
+
+// serve responds to a request whose path has been stripped of the prefix.
+func (m staticMount) serve(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/")
+	if !m.static.serves(name) {
+		slog.Info("static file does not exist", "mount", "/"+m.prefix, "file", name, KeyComponent, ComponentService)
+		writeError(w, r, model.ProcessingError{ResponseCode: http.StatusNotFound})
+		return
+	}
+	if code := m.static.forbidden(name); code != 0 {
+		slog.Info("static directory has no index file", "mount", "/"+m.prefix, "directory", name, KeyComponent, ComponentService)
+		writeError(w, r, model.ProcessingError{ResponseCode: code})
+		return
+	}
+	m.static.ServeHTTP(w, r)
+}
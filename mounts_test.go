@@ -0,0 +1,54 @@
+package pepper
+
+import (
+	"github.com/spf13/viper"
+	"testing"
+)
+
+func TestStaticMountPrefixOrdering(t *testing.T) {
+	t.Cleanup(viper.Reset)
+	dir := t.TempDir()
+	viper.Set("http.static.mounts", []map[string]interface{}{
+		{"prefix": "/docs", "directory": dir},
+		{"prefix": "/docs/api/", "directory": dir},
+		{"prefix": "downloads", "directory": dir},
+		{"prefix": "/docs/api/v2", "directory": dir},
+	})
+
+	s := Service{mounts: loadStaticMounts()}
+	var prefixes []string
+	for _, m := range s.mounts {
+		prefixes = append(prefixes, m.prefix)
+	}
+	want := []string{"docs/api/v2", "downloads", "docs/api", "docs"}
+	if len(prefixes) != len(want) {
+		t.Fatalf("mounts = %v, want %v", prefixes, want)
+	}
+	for i := range want {
+		if prefixes[i] != want[i] {
+			t.Fatalf("mounts = %v, want %v", prefixes, want)
+		}
+	}
+
+	tests := []struct {
+		path    string
+		mount   string
+		mounted bool
+	}{
+		{"docs", "docs", true},
+		{"docs/index.html", "docs", true},
+		{"docs/api", "docs/api", true},
+		{"docs/api/v1/index.html", "docs/api", true},
+		{"docs/api/v2/index.html", "docs/api/v2", true},
+		{"docs/apiary", "docs", true},
+		{"downloads/file.zip", "downloads", true},
+		{"documents/a", "", false},
+		{"", "", false},
+	}
+	for _, tt := range tests {
+		m, mounted := s.mount(tt.path)
+		if mounted != tt.mounted || m.prefix != tt.mount {
+			t.Errorf("mount(%q) = %q, %v, want %q, %v", tt.path, m.prefix, mounted, tt.mount, tt.mounted)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package pepper
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+)
+
+// layer is a single file system of an overlay, named for diagnostics.
+type layer struct {
+	name string
+	fs   fs.FS
+}
+
+// overlayFS layers file systems on top of each other: every path is served
+// by the first layer containing it, so that files on disk can override
+// embedded ones one by one. Directories list the files of all layers.
+type overlayFS struct {
+	kind   string
+	layers []layer
+}
+
+// overlayDir is a directory of an overlay, listing the merged entries of
+// the directories of the same name in all layers.
+type overlayDir struct {
+	fs.File
+	entries []fs.DirEntry
+	offset  int
+}
+
+// overlay puts the directories on top of the base file system, in order of
+// precedence. Without directories the base file system is returned as is.
+func overlay(kind string, base fs.FS, baseName string, directories []string) fs.FS {
+	if len(directories) == 0 {
+		return base
+	}
+
+	o := overlayFS{kind: kind}
+	for _, directory := range directories {
+		o.layers = append(o.layers, layer{name: directory, fs: os.DirFS(directory)})
+	}
+	o.layers = append(o.layers, layer{name: baseName, fs: base})
+	return o
+}
+
+func (o overlayFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+
+	for _, l := range o.layers {
+		f, err := l.fs.Open(name)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		info, err := f.Stat()
+		if err == nil && info.IsDir() {
+			entries, err := o.ReadDir(name)
+			if err != nil {
+				_ = f.Close()
+				return nil, err
+			}
+			return &overlayDir{File: f, entries: entries}, nil
+		}
+
+		slog.Debug("serving file from overlay", "kind", o.kind, "file", name, "layer", l.name, KeyComponent, ComponentService)
+		return f, nil
+	}
+
+	return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+}
+
+// ReadDir merges the entries of the directory in all layers, the entry of
+// the upper layer winning when names collide.
+func (o overlayFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	var (
+		entries []fs.DirEntry
+		found   bool
+		seen    = make(map[string]bool)
+	)
+	for _, l := range o.layers {
+		list, err := fs.ReadDir(l.fs, name)
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			return nil, err
+		}
+		found = true
+		for _, e := range list {
+			if !seen[e.Name()] {
+				seen[e.Name()] = true
+				entries = append(entries, e)
+			}
+		}
+	}
+
+	if !found {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+	return entries, nil
+}
+
+// Layer returns the name of the layer serving the file, or an empty string
+// if no layer contains it.
+func (o overlayFS) Layer(name string) string {
+	for _, l := range o.layers {
+		if _, err := fs.Stat(l.fs, name); err == nil {
+			return l.name
+		}
+	}
+	return ""
+}
+
+func (d *overlayDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	rest := d.entries[d.offset:]
+	if n <= 0 {
+		d.offset = len(d.entries)
+		return rest, nil
+	}
+	if len(rest) == 0 {
+		return nil, io.EOF
+	}
+	if n > len(rest) {
+		n = len(rest)
+	}
+	d.offset += n
+	return rest[:n], nil
+}
+
+// contentLayers is the debug handler listing every template and static file
+// with the layer it is served from. Like /metrics, it requires the
+// credentials of http.password.file.
+func contentLayers(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	for _, root := range []struct {
+		kind string
+		fs   fs.FS
+	}{{"templates", templatesRoot()}, {"static", staticRoot()}} {
+		_ = fs.WalkDir(root.fs, ".", func(name string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return nil
+			}
+			source := "base"
+			if o, ok := root.fs.(overlayFS); ok {
+				source = o.Layer(name)
+			}
+			_, _ = fmt.Fprintf(w, "%s\t%s\t%s\n", root.kind, name, source)
+			return nil
+		})
+	}
+}
+
+// describeLayers returns the layers of the file system for logging.
+func describeLayers(fsys fs.FS) string {
+	o, ok := fsys.(overlayFS)
+	if !ok {
+		return ""
+	}
+	names := make([]string, 0, len(o.layers))
+	for _, l := range o.layers {
+		names = append(names, l.name)
+	}
+	return strings.Join(names, ", ")
+}
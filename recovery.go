@@ -0,0 +1,119 @@
+package pepper
+
+import (
+	"fmt"
+	"github.com/iktech/pepper/model"
+	"github.com/prometheus/client_golang/prometheus"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+)
+
+var PanicsTotal = prometheus.NewCounter(
+	prometheus.CounterOpts{
+		Name: "http_router_panics_total",
+		Help: "Number of panics recovered while handling HTTP requests",
+	},
+)
+
+var debugPanicPage = template.Must(template.New("panic").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>500 Internal Server Error</title>
+<style>body{font-family:sans-serif;margin:2em}pre{background:#f4f4f4;padding:1em;overflow:auto}</style>
+</head>
+<body>
+<h1>panic: {{ .Panic }}</h1>
+<p>{{ .Method }} {{ .Path }}, request {{ .RequestID }}</p>
+<pre>{{ .Stack }}</pre>
+</body>
+</html>
+`))
+
+// recoveryResponseWriter records whether the response has been started, in
+// which case an error page can no longer be sent.
+type recoveryResponseWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+}
+
+// Recovery recovers from panics in controllers and template functions. The
+// panic is logged with its stack and counted, and the 500 error page is
+// sent, or a page showing the panic and the stack if the error overlay is
+// enabled. It must be placed inside Logging so that the response is logged.
+func Recovery() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			rw := &recoveryResponseWriter{ResponseWriter: w}
+			defer func() {
+				v := recover()
+				if v == nil {
+					return
+				}
+				if v == http.ErrAbortHandler {
+					panic(v)
+				}
+
+				stack := debug.Stack()
+				requestID, _ := r.Context().Value(requestIDKey).(string)
+				PanicsTotal.Inc()
+				slog.Error("panic while handling request", "panic", fmt.Sprint(v), "stack", string(stack), "request_id", requestID, "route", r.URL.Path, KeyComponent, ComponentService)
+
+				if rw.wroteHeader {
+					// The response has been started and cannot be replaced by
+					// an error page, so the connection is aborted instead.
+					panic(http.ErrAbortHandler)
+				}
+
+				for _, name := range []string{"Cache-Control", "Content-Encoding", "Content-Length", "ETag", "Last-Modified"} {
+					w.Header().Del(name)
+				}
+
+				pe := model.ProcessingError{ResponseCode: http.StatusInternalServerError}
+				if !errorOverlayEnabled() {
+					writeError(w, r, pe)
+					return
+				}
+
+				pe.Data = fmt.Sprintf("panic: %v", v)
+				if errorContentType(r) != contentTypeHTML {
+					writeError(w, r, pe)
+					return
+				}
+				w.Header().Set("Content-Type", "text/html; charset=utf-8")
+				w.WriteHeader(http.StatusInternalServerError)
+				err := debugPanicPage.Execute(w, map[string]interface{}{
+					"Panic":     fmt.Sprint(v),
+					"Method":    r.Method,
+					"Path":      r.URL.Path,
+					"RequestID": requestID,
+					"Stack":     string(stack),
+				})
+				if err != nil {
+					slog.Error("cannot write response body", KeyError, err, KeyComponent, ComponentService)
+				}
+			}()
+
+			next.ServeHTTP(rw, r)
+		})
+	}
+}
+
+func (rw *recoveryResponseWriter) WriteHeader(code int) {
+	rw.wroteHeader = true
+	rw.ResponseWriter.WriteHeader(code)
+}
+
+func (rw *recoveryResponseWriter) Write(b []byte) (int, error) {
+	rw.wroteHeader = true
+	return rw.ResponseWriter.Write(b)
+}
+
+func (rw *recoveryResponseWriter) Flush() {
+	rw.wroteHeader = true
+	if f, ok := rw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
@@ -0,0 +1,111 @@
+package pepper
+
+import (
+	"compress/gzip"
+	"github.com/iktech/pepper/controllers"
+	"github.com/spf13/viper"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRecoveryStackPage(t *testing.T) {
+	t.Cleanup(viper.Reset)
+	previousDebug, previousPages := controllers.Debug, ErrorPages
+	t.Cleanup(func() { controllers.Debug, ErrorPages = previousDebug, previousPages })
+	ErrorPages = map[int]*ErrorPageDefinition{500: {Name: "500.html", IsDefault: true}}
+
+	handler := Recovery()(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		panic("secret state")
+	}))
+
+	tests := []struct {
+		name    string
+		debug   bool
+		overlay bool
+		stack   bool
+	}{
+		{"production", false, false, false},
+		{"debug logging only", true, false, false},
+		{"overlay without debug", false, true, false},
+		{"overlay", true, true, true},
+	}
+	for _, tt := range tests {
+		controllers.Debug = tt.debug
+		viper.Set("http.debug.errorOverlay", tt.overlay)
+
+		rec := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/page", nil)
+		r.Header.Set("Accept", "text/html")
+		handler.ServeHTTP(rec, r)
+
+		if rec.Code != 500 {
+			t.Errorf("%s: status = %d, want 500", tt.name, rec.Code)
+		}
+		body := rec.Body.String()
+		if shown := strings.Contains(body, "secret state") || strings.Contains(body, "goroutine"); shown != tt.stack {
+			t.Errorf("%s: panic shown = %v, want %v", tt.name, shown, tt.stack)
+		}
+	}
+}
+
+// headerRecorder records whether the response header has been written.
+type headerRecorder struct {
+	*httptest.ResponseRecorder
+	wroteHeader bool
+}
+
+func (h *headerRecorder) WriteHeader(code int) {
+	h.wroteHeader = true
+	h.ResponseRecorder.WriteHeader(code)
+}
+
+func TestRecoveryInsideCompression(t *testing.T) {
+	t.Cleanup(viper.Reset)
+	viper.Set("http.compression.enabled", true)
+	viper.Set("http.compression.minSize", 1024)
+	viper.Set("http.compression.types", []string{"text/html"})
+
+	tests := []struct {
+		name string
+		body string
+	}{
+		{"buffered body", "<p>partial"},
+		{"compressed body", "<p>" + strings.Repeat("partial ", 512)},
+	}
+	for _, tt := range tests {
+		handler := Compression()(Recovery()(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.Header().Set("Content-Type", "text/html")
+			_, _ = w.Write([]byte(tt.body))
+			panic("after a partial write")
+		})))
+
+		rec := &headerRecorder{ResponseRecorder: httptest.NewRecorder()}
+		r := httptest.NewRequest("GET", "/page", nil)
+		r.Header.Set("Accept-Encoding", "gzip")
+		func() {
+			defer func() {
+				if v := recover(); v != http.ErrAbortHandler {
+					t.Errorf("%s: panic = %v, want %v", tt.name, v, http.ErrAbortHandler)
+				}
+			}()
+			handler.ServeHTTP(rec, r)
+		}()
+
+		if len(tt.body) < 1024 {
+			if rec.wroteHeader || rec.Body.Len() != 0 {
+				t.Errorf("%s: status %d with %d bytes sent, want nothing", tt.name, rec.Code, rec.Body.Len())
+			}
+			continue
+		}
+		zr, err := gzip.NewReader(rec.Body)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if _, err := io.ReadAll(zr); err != io.ErrUnexpectedEOF {
+			t.Errorf("%s: reading the body = %v, want %v", tt.name, err, io.ErrUnexpectedEOF)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package pepper
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/iktech/pepper/controllers"
+	"github.com/iktech/pepper/model"
+	"github.com/spf13/viper"
+	"log/slog"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+)
+
+// RobotsRule is a group of robots.txt directives for a user agent.
+type RobotsRule struct {
+	UserAgent  string   `mapstructure:"userAgent"`
+	Allow      []string `mapstructure:"allow"`
+	Disallow   []string `mapstructure:"disallow"`
+	CrawlDelay int      `mapstructure:"crawlDelay"`
+}
+
+// robots is the controller generating robots.txt from the configuration.
+type robots struct {
+	routerMap map[string]controllers.Controller
+	rules     []RobotsRule
+	protected func(string) bool
+}
+
+// Environment returns the name of the deployment environment, taken from
+// http.environment or, if not set, from opentracing.environment.
+func Environment() string {
+	if env := viper.GetString("http.environment"); env != "" {
+		return env
+	}
+	return viper.GetString("opentracing.environment")
+}
+
+// addRobots registers the generated robots.txt in the router map. It is
+// always generated in the environments listed in
+// http.robots.blockedEnvironments, so that they are never indexed. The
+// sitemap is only referred to if protected returns false for it.
+func addRobots(routerMap map[string]controllers.Controller, protected func(string) bool) {
+	if !viper.GetBool("http.robots.enabled") && !isBlockedEnvironment() {
+		return
+	}
+	if _, found := routerMap["robots.txt"]; found && !isBlockedEnvironment() {
+		slog.Info("robots.txt is served by a custom controller", KeyComponent, ComponentService)
+		return
+	}
+
+	var rules []RobotsRule
+	key := "http.robots.rules"
+	if env := Environment(); env != "" && viper.IsSet("http.robots.environments."+env) {
+		key = "http.robots.environments." + env
+	}
+	if err := viper.UnmarshalKey(key, &rules); err != nil {
+		slog.Error("cannot read robots rules", KeyError, err, KeyComponent, ComponentService)
+		os.Exit(1)
+	}
+
+	if isBlockedEnvironment() {
+		slog.Info("search engines are disallowed in this environment", "environment", Environment(), KeyComponent, ComponentService)
+		rules = []RobotsRule{{UserAgent: "*", Disallow: []string{"/"}}}
+	}
+
+	routerMap["robots.txt"] = robots{routerMap: routerMap, rules: rules, protected: protected}
+}
+
+func (rb robots) Handle(r *http.Request) (int, string, string, *bytes.Buffer, *model.ProcessingError) {
+	var buf bytes.Buffer
+	rules := rb.rules
+	if len(rules) == 0 {
+		rules = []RobotsRule{{UserAgent: "*"}}
+	}
+
+	for i, rule := range rules {
+		if i > 0 {
+			buf.WriteString("\n")
+		}
+		userAgent := rule.UserAgent
+		if userAgent == "" {
+			userAgent = "*"
+		}
+		_, _ = fmt.Fprintf(&buf, "User-agent: %s\n", userAgent)
+		for _, allow := range rule.Allow {
+			_, _ = fmt.Fprintf(&buf, "Allow: %s\n", allow)
+		}
+		for _, disallow := range rule.Disallow {
+			_, _ = fmt.Fprintf(&buf, "Disallow: %s\n", disallow)
+		}
+		if len(rule.Allow) == 0 && len(rule.Disallow) == 0 {
+			buf.WriteString("Disallow:\n")
+		}
+		if rule.CrawlDelay > 0 {
+			_, _ = fmt.Fprintf(&buf, "Crawl-delay: %d\n", rule.CrawlDelay)
+		}
+	}
+
+	if _, found := rb.routerMap["sitemap.xml"]; found && !isBlockedEnvironment() && !rb.protected("sitemap.xml") {
+		_, _ = fmt.Fprintf(&buf, "\nSitemap: %s/sitemap.xml\n", SiteURL(r))
+	}
+
+	return 200, "", "text/plain; charset=utf-8", &buf, nil
+}
+
+// robotsFor returns the robots directives configured for the path: pages
+// matching http.robots.noindex are not indexed, and nothing is indexed or
+// followed in a blocked environment. Patterns ending with "*" match by prefix.
+func robotsFor(path string) string {
+	if isBlockedEnvironment() {
+		return "noindex, nofollow"
+	}
+
+	path = strings.Trim(path, "/")
+	for _, pattern := range viper.GetStringSlice("http.robots.noindex") {
+		pattern = strings.TrimPrefix(pattern, "/")
+		if prefix, found := strings.CutSuffix(pattern, "*"); found {
+			if strings.HasPrefix(path, prefix) {
+				return "noindex"
+			}
+		} else if strings.Trim(pattern, "/") == path {
+			return "noindex"
+		}
+	}
+	return ""
+}
+
+// robotsTag returns the robots directives of a route, preferring those given
+// by the controller itself.
+func robotsTag(route controllers.Controller, path string) string {
+	if isBlockedEnvironment() {
+		return robotsFor(path)
+	}
+	if c, ok := route.(controllers.Indexed); ok {
+		if directives := c.RobotsTag(); directives != "" {
+			return directives
+		}
+	}
+	return robotsFor(path)
+}
+
+func isBlockedEnvironment() bool {
+	env := Environment()
+	return env != "" && slices.Contains(viper.GetStringSlice("http.robots.blockedEnvironments"), env)
+}
+
+func isNoIndex(directives string) bool {
+	for _, directive := range strings.Split(directives, ",") {
+		directive = strings.TrimSpace(strings.ToLower(directive))
+		if directive == "noindex" || directive == "none" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,79 @@
+package pepper
+
+import (
+	"github.com/iktech/pepper/controllers"
+	"github.com/iktech/pepper/model"
+	"github.com/spf13/viper"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRobotsTag(t *testing.T) {
+	tests := []struct {
+		name        string
+		environment string
+		noindex     []string
+		route       controllers.Controller
+		path        string
+		want        string
+	}{
+		{name: "default", path: "about", want: ""},
+		{name: "exact pattern", noindex: []string{"/drafts"}, path: "drafts", want: "noindex"},
+		{name: "exact pattern other path", noindex: []string{"/drafts"}, path: "drafts/a", want: ""},
+		{name: "prefix pattern", noindex: []string{"/private/*"}, path: "private/a", want: "noindex"},
+		{name: "controller", route: controllers.Model{Model: &model.Model{Robots: "noindex, nofollow"}}, path: "about", want: "noindex, nofollow"},
+		{name: "controller without directives", route: controllers.Model{Model: &model.Model{}}, noindex: []string{"about"}, path: "about", want: "noindex"},
+		{name: "blocked environment", environment: "staging", route: controllers.Model{Model: &model.Model{Robots: "index"}}, path: "about", want: "noindex, nofollow"},
+		{name: "other environment", environment: "production", path: "about", want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Cleanup(viper.Reset)
+			viper.Set("http.environment", tt.environment)
+			viper.Set("http.robots.blockedEnvironments", []string{"staging"})
+			viper.Set("http.robots.noindex", tt.noindex)
+
+			if got := robotsTag(tt.route, tt.path); got != tt.want {
+				t.Errorf("robotsTag() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsNoIndex(t *testing.T) {
+	tests := map[string]bool{
+		"":                  false,
+		"nofollow":          false,
+		"noindex":           true,
+		"NoIndex, nofollow": true,
+		"none":              true,
+		"noimageindex":      false,
+	}
+	for directives, want := range tests {
+		if got := isNoIndex(directives); got != want {
+			t.Errorf("isNoIndex(%q) = %v, want %v", directives, got, want)
+		}
+	}
+}
+
+func TestRobotsSitemapReference(t *testing.T) {
+	t.Cleanup(viper.Reset)
+	viper.Set("http.site.baseUrl", "https://example.com")
+
+	routerMap := map[string]controllers.Controller{"sitemap.xml": sitemap{}}
+	tests := []struct {
+		name      string
+		protected func(string) bool
+		want      bool
+	}{
+		{name: "public", protected: func(string) bool { return false }, want: true},
+		{name: "protected", protected: func(name string) bool { return name == "sitemap.xml" }, want: false},
+	}
+	for _, tt := range tests {
+		_, _, _, buf, _ := robots{routerMap: routerMap, protected: tt.protected}.Handle(httptest.NewRequest("GET", "/robots.txt", nil))
+		if got := strings.Contains(buf.String(), "Sitemap: https://example.com/sitemap.xml"); got != tt.want {
+			t.Errorf("%s: sitemap referred to = %v, want %v in %q", tt.name, got, tt.want, buf.String())
+		}
+	}
+}
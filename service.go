@@ -1,9 +1,9 @@
 package pepper
 
 import (
-	"bytes"
 	"context"
 	"embed"
+	"errors"
 	"fmt"
 	"github.com/iktech/pepper/authentication"
 	"github.com/iktech/pepper/controllers"
@@ -18,9 +18,9 @@ import (
 	"go.opentelemetry.io/otel/sdk/resource"
 	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 	semconv "go.opentelemetry.io/otel/semconv/v1.25.0"
+	"golang.org/x/crypto/bcrypt"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials/insecure"
-	"html/template"
 	"io/fs"
 	"log/slog"
 	"net/http"
@@ -38,6 +38,7 @@ const (
 	KeyComponent       = "component"
 	ComponentService   = "service"
 	ComponentAccessLog = "access_log"
+	ComponentExport    = "export"
 )
 
 type Redirect struct {
@@ -53,10 +54,13 @@ type ErrorPageDefinition struct {
 }
 
 type Service struct {
-	staticHandler http.Handler
+	staticHandler *staticFileHandler
 	templates     fs.FS
 	routerMap     map[string]controllers.Controller
 	redirects     map[string]Redirect
+	fallbacks     []SPAFallback
+	mounts        []staticMount
+	authRules     []authRule
 }
 
 var (
@@ -82,9 +86,72 @@ var (
 		[]string{"code", "method", "path"},
 	)
 	Server *http.Server
+	site   Service
 )
 
 func CreateService(sf embed.FS, t embed.FS, customize func(map[string]controllers.Controller) map[string]controllers.Controller) func(ctx context.Context) error {
+	useEmbedded := configure(sf, t)
+
+	prometheus.MustRegister(RequestDurationGauge)
+	prometheus.MustRegister(RequestDurationSummary)
+	prometheus.MustRegister(PanicsTotal)
+	prometheus.MustRegister(MaintenanceGauge)
+	prometheus.MustRegister(authentication.FailuresTotal)
+	prometheus.MustRegister(authentication.LockoutsTotal)
+	prometheus.MustRegister(authentication.ThrottledTotal)
+	nextRequestID := func() string {
+		return fmt.Sprintf("%d", time.Now().UnixNano())
+	}
+
+	loginThrottle = newLoginThrottle()
+	var prometheusHandler = basicAuth("", "", nil)(promhttp.Handler())
+	var shutdown func(ctx context.Context) error
+
+	http.Handle("/metrics", prometheusHandler)
+	if Debug {
+		http.Handle("/debug/content", basicAuth("", "", nil)(http.HandlerFunc(contentLayers)))
+	}
+	if endpoint := viper.GetString("http.maintenance.endpoint"); endpoint != "" {
+		http.Handle(endpoint, basicAuth("", "", nil)(http.HandlerFunc(maintenanceEndpoint)))
+	}
+	if errorOverlayEnabled() {
+		slog.Warn("template errors and panics are shown in detail, this must not be enabled in production", KeyComponent, ComponentService)
+	}
+	site = requestHandler(useEmbedded, customize)
+	http.Handle(viper.GetString("http.context"), Tracing(nextRequestID)(Logging()(Compression()(Recovery()(Maintenance()(site))))))
+	// Launch web server on port 80
+	Port = viper.GetInt("http.port")
+	Server = &http.Server{
+		Addr: ":" + strconv.Itoa(Port),
+	}
+
+	if viper.GetString("opentracing.tracerEndpoint") != "" {
+		var err error
+		shutdown, err = initProvider(viper.GetString("opentracing.tracerEndpoint"), viper.GetString("opentracing.serviceName"), viper.GetString("opentracing.environment"))
+		if err != nil {
+			slog.Warn("cannot initialize Open Telemetry tracing", "error", err)
+		}
+	}
+
+	return shutdown
+}
+
+// CreateExport configures the site like CreateService, for Export rather
+// than for serving it: metrics, the debug and maintenance endpoints and
+// tracing are left out, and the password files of protected routes, which
+// are not exported, are neither read nor watched.
+func CreateExport(sf embed.FS, t embed.FS, customize func(map[string]controllers.Controller) map[string]controllers.Controller) {
+	useEmbedded := configure(sf, t)
+
+	exporting = true
+	defer func() { exporting = false }()
+	site = requestHandler(useEmbedded, customize)
+}
+
+// configure applies the defaults and environment bindings of the
+// configuration and defines the default error pages. It returns whether the
+// embedded content is used.
+func configure(sf embed.FS, t embed.FS) bool {
 	staticFiles = sf
 	templates = t
 
@@ -95,20 +162,54 @@ func CreateService(sf embed.FS, t embed.FS, customize func(map[string]controller
 	viper.SetDefault("http.content.templatesDirectory", "templates")
 	viper.SetDefault("http.content.staticDirectory", "static")
 	viper.SetDefault("http.port", 8888)
+	viper.SetDefault("http.trustForwardedFor", false)
 	viper.SetDefault("http.context", "/")
 	viper.SetDefault("http.password.file", "/etc/pepper/.passwd")
+	viper.SetDefault("http.password.cost", bcrypt.DefaultCost)
+	viper.SetDefault("http.auth.realm", authentication.DefaultRealm)
+	viper.SetDefault("http.auth.throttle.enabled", true)
+	viper.SetDefault("http.auth.throttle.maxFailures", 5)
+	viper.SetDefault("http.auth.throttle.maxFailuresPerIP", 20)
+	viper.SetDefault("http.auth.throttle.baseDelay", "1s")
+	viper.SetDefault("http.auth.throttle.maxDelay", "15m")
+	viper.SetDefault("http.auth.throttle.window", "15m")
+	viper.SetDefault("http.sitemap.enabled", true)
+	viper.SetDefault("http.sitemap.static", true)
+	viper.SetDefault("http.sitemap.maxUrls", 50000)
+	viper.SetDefault("http.robots.enabled", false)
+	viper.SetDefault("http.robots.blockedEnvironments", []string{"staging"})
+	viper.SetDefault("http.debug.errorOverlay", false)
+	viper.SetDefault("http.errorPageLegacyData", false)
+	viper.SetDefault("http.maintenance.enabled", false)
+	viper.SetDefault("http.maintenance.retryAfter", "300")
+	viper.SetDefault("http.maintenance.exclude", []string{"healthz", "ready"})
+	viper.SetDefault("http.maintenance.endpoint", "")
+	viper.SetDefault("http.maintenance.checkInterval", "1s")
+	viper.SetDefault("http.static.precompressed", true)
+	viper.SetDefault("http.static.indexFiles", []string{"index.html"})
+	viper.SetDefault("http.static.missingIndex", 404)
+	viper.SetDefault("http.compression.enabled", true)
+	viper.SetDefault("http.compression.minSize", 1024)
+	viper.SetDefault("http.compression.types", []string{"text/html", "text/css", "text/plain", "text/xml", "text/javascript", "application/javascript", "application/json", "application/problem+json", "application/xml", "application/rss+xml", "application/atom+xml", "image/svg+xml"})
+	viper.SetDefault("http.assets.fingerprint", true)
+	viper.SetDefault("http.assets.patterns", []string{"*.css", "*.js", "*.mjs", "*.png", "*.jpg", "*.jpeg", "*.gif", "*.svg", "*.webp", "*.avif", "*.ico", "*.woff", "*.woff2"})
+	viper.SetDefault("http.assets.cacheControl", "public, max-age=31536000, immutable")
 
 	_ = viper.BindEnv("http.content.useEmbedded", "HTTP_USE_EMBEDDED")
 	_ = viper.BindEnv("http.password.file", "HTTP_PASSWORD_FILE")
+	_ = viper.BindEnv("http.password.reloadInterval", "HTTP_PASSWORD_RELOAD_INTERVAL")
+	_ = viper.BindEnv("http.password.cost", "HTTP_PASSWORD_COST")
+	_ = viper.BindEnv("http.site.baseUrl", "HTTP_SITE_BASE_URL")
+	_ = viper.BindEnv("http.environment", "HTTP_ENVIRONMENT")
+	_ = viper.BindEnv("http.maintenance.enabled", "HTTP_MAINTENANCE")
+	_ = viper.BindEnv("http.maintenance.file", "HTTP_MAINTENANCE_FILE")
 	_ = viper.BindEnv("google.analytics.id", "GOOGLE_ANALYTICS_ID")
 	_ = viper.BindEnv("opentracing.tracerEndpoint", "OTEL_TRACER_ENDPOINT")
 	_ = viper.BindEnv("opentracing.serviceName", "OTEL_SERVICE_NAME")
 	_ = viper.BindEnv("opentracing.environment", "OTEL_ENVIRONMENT")
 
 	controllers.Debug = Debug
-	useEmbedded := viper.GetBool("http.content.useEmbedded")
 	GoogleAnayticsId = viper.GetString("google.analytics.id")
-	// Launch web server on port 80
 	ErrorPages = make(map[int]*ErrorPageDefinition)
 	ErrorPages[400] = &ErrorPageDefinition{
 		Name:      "400.html",
@@ -140,34 +241,16 @@ func CreateService(sf embed.FS, t embed.FS, customize func(map[string]controller
 		IsDefault: true,
 	}
 
-	prometheus.MustRegister(RequestDurationGauge)
-	prometheus.MustRegister(RequestDurationSummary)
-	nextRequestID := func() string {
-		return fmt.Sprintf("%d", time.Now().UnixNano())
-	}
-
-	var ba = &authentication.BasicAuthHandler{}
-	var prometheusHandler = ba.BasicAuth(viper.GetString("http.password.file"))(promhttp.Handler())
-	var shutdown func(ctx context.Context) error
-
-	http.Handle("/metrics", prometheusHandler)
-	http.Handle(viper.GetString("http.context"), Tracing(nextRequestID)(Logging()(requestHandler(useEmbedded, customize))))
-	Port = viper.GetInt("http.port")
-	Server = &http.Server{
-		Addr: ":" + strconv.Itoa(Port),
-	}
-
-	if viper.GetString("opentracing.tracerEndpoint") != "" {
-		var err error
-		shutdown, err = initProvider(viper.GetString("opentracing.tracerEndpoint"), viper.GetString("opentracing.serviceName"), viper.GetString("opentracing.environment"))
-		if err != nil {
-			slog.Warn("cannot initialize Open Telemetry tracing", "error", err)
-		}
+	ErrorPages[503] = &ErrorPageDefinition{
+		Name:      "503.html",
+		IsDefault: true,
 	}
 
-	return shutdown
+	return viper.GetBool("http.content.useEmbedded")
 }
 
+// Run starts the web server. Sites are exported with the pepper export
+// command, or by calling CreateExport and Export instead.
 func Run() {
 	if err := Server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 		slog.Error("cannot start server", KeyError, err, KeyComponent, ComponentService)
@@ -175,22 +258,22 @@ func Run() {
 	}
 }
 
-func requestHandler(useEmbedded bool, customise func(map[string]controllers.Controller) map[string]controllers.Controller) http.Handler {
-	var staticHandler http.Handler
+func requestHandler(useEmbedded bool, customise func(map[string]controllers.Controller) map[string]controllers.Controller) Service {
+	var staticHandler *staticFileHandler
 
 	includes := viper.GetStringSlice("http.includes")
 
 	routerMap := make(map[string]controllers.Controller)
 	controls := viper.GetStringMapString("http.controllers")
-	var fsRoot fs.FS
 	if useEmbedded {
 		slog.Info("using embedded templates", KeyComponent, ComponentService)
-		fsRoot, _ = fs.Sub(templates, viper.GetString("http.content.templatesDirectory"))
 	} else {
 		slog.Info("using templates from the file system", KeyComponent, ComponentService)
 		templates = os.DirFS(viper.GetString("http.content.templatesDirectory"))
-		fsRoot = templates
-		//fsRoot, _ = fs.Sub(templates, viper.GetString("http.content.templatesDirectory"))
+	}
+	fsRoot := templatesRoot()
+	if layers := describeLayers(fsRoot); layers != "" {
+		slog.Info("using template overlay", "layers", layers, KeyComponent, ComponentService)
 	}
 	for key, value := range controls {
 		routerMap[key] = controllers.Model{
@@ -200,21 +283,57 @@ func requestHandler(useEmbedded bool, customise func(map[string]controllers.Cont
 				TemplatesDirectory: fsRoot,
 				Includes:           includes,
 				GoogleAnalyticsId:  GoogleAnayticsId,
+				Robots:             robotsFor(key),
 			},
 		}
 	}
 
+	// Feeds check entries against the auth rules when they are served, since
+	// the rules can only be loaded once all routes are known.
+	var service Service
+	loadCollections(fsRoot, includes, routerMap, func(name string) bool {
+		return service.protected(name)
+	})
+
 	routerMap = customise(routerMap)
-	fsRoot, _ = fs.Sub(staticFiles, viper.GetString("http.content.staticDirectory"))
-	var static = http.FS(fsRoot)
+	service.mounts = loadStaticMounts()
+	service.authRules = loadAuthRules(routerMap, service.mounts)
+	if viper.GetBool("http.sitemap.enabled") {
+		addSitemap(routerMap, staticRoot(), service.protected)
+	}
+	addRobots(routerMap, service.protected)
 
 	if useEmbedded {
 		slog.Info("using embedded content", KeyComponent, ComponentService)
-		staticHandler = http.FileServer(static)
-
 	} else {
 		slog.Info("using content from the file system", KeyComponent, ComponentService)
-		staticHandler = http.FileServer(http.Dir(viper.GetString("http.content.staticDirectory")))
+	}
+
+	if layers := describeLayers(staticRoot()); layers != "" {
+		slog.Info("using static content overlay", "layers", layers, KeyComponent, ComponentService)
+	}
+
+	var cacheRules []CacheRule
+	if err := viper.UnmarshalKey("http.static.cache", &cacheRules); err != nil {
+		slog.Error("cannot read static cache rules", KeyError, err, KeyComponent, ComponentService)
+		os.Exit(1)
+	}
+	embedded := useEmbedded && len(viper.GetStringSlice("http.content.overlay.static")) == 0
+	staticHandler = newStaticHandler(staticRoot(), cacheRules, embedded)
+	staticHandler.precompressed = viper.GetBool("http.static.precompressed")
+	staticHandler.indexFiles = viper.GetStringSlice("http.static.indexFiles")
+	staticHandler.listing = viper.GetStringSlice("http.static.listing")
+	staticHandler.missingIndex = viper.GetInt("http.static.missingIndex")
+	if staticHandler.missingIndex != 403 && staticHandler.missingIndex != 404 {
+		slog.Error("unexpected status for directories without index file", "code", staticHandler.missingIndex, KeyComponent, ComponentService)
+		os.Exit(1)
+	}
+	if viper.GetBool("http.assets.fingerprint") {
+		staticHandler.assetPatterns = viper.GetStringSlice("http.assets.patterns")
+		staticHandler.assetControl = viper.GetString("http.assets.cacheControl")
+		if manifest := viper.GetString("http.assets.manifest"); manifest != "" {
+			addAssetManifest(routerMap, staticHandler, manifest)
+		}
 	}
 
 	redirectsMap := viper.GetStringMap("http.redirects")
@@ -246,7 +365,7 @@ func requestHandler(useEmbedded bool, customise func(map[string]controllers.Cont
 		code := 404
 		code, err = strconv.Atoi(key)
 		if err != nil {
-			slog.Error("unexpected error code %s in error pages definition", key, KeyComponent, ComponentService)
+			slog.Error("unexpected error code in error pages definition", "code", key, KeyComponent, ComponentService)
 			os.Exit(1)
 		}
 
@@ -264,7 +383,14 @@ func requestHandler(useEmbedded bool, customise func(map[string]controllers.Cont
 		}
 	}
 
-	return Service{staticHandler, templates, routerMap, redirects}
+	errorPageScopes = loadErrorPageScopes()
+
+	service.staticHandler = staticHandler
+	service.templates = templates
+	service.routerMap = routerMap
+	service.redirects = redirects
+	service.fallbacks = loadSPAFallbacks(staticHandler)
+	return service
 }
 
 func (s Service) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -277,6 +403,18 @@ func (s Service) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	path := strings.TrimPrefix(r.URL.Path, "/")
 	span.SetAttributes(attribute.String("resource", path))
+	if rule, protected := s.authRule(path); protected && rule.protect != nil {
+		authenticated := false
+		rule.protect(http.HandlerFunc(func(_ http.ResponseWriter, rq *http.Request) {
+			authenticated = true
+			r = rq
+		})).ServeHTTP(w, r)
+		if !authenticated {
+			span.SetAttributes(attribute.String("event", "unauthorised"), attribute.String("rule", rule.Name))
+			return
+		}
+	}
+
 	redirect, found := s.redirects[path]
 	if found {
 		span.SetAttributes(attribute.String("event", "redirect"), attribute.String("location", redirect.Location))
@@ -286,57 +424,60 @@ func (s Service) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 
 	route := s.routerMap[path]
-	if route == nil {
+	if directives := robotsTag(route, path); directives != "" {
+		w.Header().Set("X-Robots-Tag", directives)
+	}
+
+	mount, mounted := s.mount(path)
+	if route == nil && mounted {
+		span.SetAttributes(attribute.String("event", "static-mount"), attribute.String("mount", "/"+mount.prefix))
+		mount.ServeHTTP(w, r)
+	} else if route == nil {
 		span.SetAttributes(attribute.String("event", "static-file"))
-		if staticFileExists(path) {
+		fallback, spa := s.spaFallback(path)
+		if spa {
+			addVary(w.Header(), "Accept")
+		}
+		if s.staticHandler.serves(path) {
+			if code := s.staticHandler.forbidden(path); code != 0 {
+				message := fmt.Sprintf("static directory %s has no index file", path)
+				span.SetAttributes(attribute.String("event", "controller-error"), attribute.String("message", message))
+				slog.Info(message, KeyComponent, ComponentService)
+				writeError(w, r, model.ProcessingError{ResponseCode: code})
+				return
+			}
 			s.staticHandler.ServeHTTP(w, r)
+		} else if spa && acceptsHTML(r) {
+			span.SetAttributes(attribute.String("event", "spa-fallback"))
+			fallback.serve(s.staticHandler, w, r)
 		} else {
 			message := fmt.Sprintf("static file %s does not exist", path)
 			span.SetAttributes(attribute.String("event", "controller-error"), attribute.String("message", message))
 			slog.Info(message, KeyComponent, ComponentService)
-			b, err := GetErrorPageContent(model.ProcessingError{ResponseCode: 404})
-			if err != nil {
-				slog.Error("cannot read error page content", KeyError, err, KeyComponent, ComponentService)
-			}
-
-			w.WriteHeader(404)
-			w.Header().Set("Content-Type", "text/html")
-			_, err = w.Write(b)
-			if err != nil {
-				slog.Error("cannot write response body", KeyError, err, KeyComponent, ComponentService)
-			}
+			writeError(w, r, model.ProcessingError{ResponseCode: 404})
 			return
 		}
 	} else {
 		span.SetAttributes(attribute.String("event", "handler"))
+		if c, ok := route.(controllers.Compressible); ok && !c.Compressible() {
+			disableCompression(r)
+		}
 		code, redirectUrl, contentType, b, controllerError := route.Handle(r)
 		if controllerError != nil {
 			message := fmt.Sprintf("cannot handle request %s: %v", path, controllerError)
 			span.SetAttributes(attribute.String("event", "controller-error"), attribute.String("message", message))
 			slog.Info(message, KeyComponent, ComponentService)
-			w.WriteHeader(controllerError.ResponseCode)
-
+			if b == nil {
+				writeError(w, r, *controllerError)
+				return
+			}
 			if contentType == "" {
 				contentType = "text/html"
 			}
 
 			w.Header().Set("Content-Type", contentType)
-
-			var (
-				errorPageContent []byte
-				err              error
-			)
-
-			if b == nil {
-				errorPageContent, err = GetErrorPageContent(*controllerError)
-				if err != nil {
-					slog.Error("cannot read error page content", KeyError, err, KeyComponent, ComponentService)
-				}
-			} else {
-				errorPageContent = b.Bytes()
-			}
-
-			_, err = w.Write(errorPageContent)
+			w.WriteHeader(controllerError.ResponseCode)
+			_, err := w.Write(b.Bytes())
 			if err != nil {
 				slog.Error("cannot write response body", KeyError, err, KeyComponent, ComponentService)
 			}
@@ -357,9 +498,20 @@ func (s Service) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
+		if hp, ok := route.(controllers.HeaderProvider); ok {
+			for name, values := range hp.Headers(r) {
+				w.Header()[name] = values
+			}
+			if code == 200 && notModified(r, w.Header()) {
+				span.SetAttributes(attribute.String("event", "not-modified"))
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		}
+
 		span.SetAttributes(attribute.String("event", "response"), attribute.Int("code", code), attribute.String("content-type", contentType))
-		w.WriteHeader(code)
 		w.Header().Set("Content-Type", contentType)
+		w.WriteHeader(code)
 		_, err := w.Write(b.Bytes())
 		if err != nil {
 			slog.Error("controller error", KeyError, controllerError, KeyComponent, ComponentService)
@@ -367,29 +519,85 @@ func (s Service) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// ErrorPageContext is the data error page templates are executed with. It
+// embeds the page model, so that the includes shared with the other pages
+// can be used.
+type ErrorPageContext struct {
+	*model.Model
+	Status      int
+	StatusText  string
+	RequestPath string
+	RequestID   string
+	Error       string
+	Data        interface{}
+	Site        map[string]interface{}
+}
+
+// GetErrorPageContent returns the error page of the processing error,
+// rendered without request details.
 func GetErrorPageContent(pe model.ProcessingError) ([]byte, error) {
-	errorDefinition := ErrorPages[pe.ResponseCode]
+	return GetRequestErrorPageContent(pe, nil)
+}
+
+// GetRequestErrorPageContent returns the error page of the processing error
+// for the request, which may be nil, taking error page scopes into account.
+// Template error pages are rendered with an ErrorPageContext, the message of
+// the originating error being included in debug mode only. Templates written
+// for the data of the error alone, as passed before ErrorPageContext was
+// introduced, fail with it and are rendered again with that data; with
+// http.errorPageLegacyData set they are given the data straight away.
+func GetRequestErrorPageContent(pe model.ProcessingError, r *http.Request) ([]byte, error) {
+	errorDefinition := errorPage(pe.ResponseCode, r)
 	if errorDefinition != nil {
 		if errorDefinition.IsTemplate {
-			var fsRoot fs.FS
-			if viper.GetBool("http.content.useEmbedded") {
-				fsRoot, _ = fs.Sub(templates, viper.GetString("http.content.templatesDirectory"))
-			} else {
-				fsRoot = templates
+			page := model.Model{
+				Template:           errorDefinition.Name,
+				TemplatesDirectory: templatesRoot(),
+				Includes:           viper.GetStringSlice("http.includes"),
+				GoogleAnalyticsId:  GoogleAnayticsId,
+				Robots:             "noindex",
+			}
+			data := pe.Data
+			if data == nil {
+				data = errorDefinition.Data
+			}
+			if viper.GetBool("http.errorPageLegacyData") {
+				_, _, _, buf, renderError := page.RenderRequest(controllers.Debug, r, data)
+				if renderError != nil {
+					slog.Error(fmt.Sprintf("cannot render template %s", errorDefinition.Name), KeyError, renderError.Err, KeyComponent, ComponentService)
+					return nil, renderError.Err
+				}
+				return buf.Bytes(), nil
 			}
-			patterns := []string{errorDefinition.Name}
-			patterns = append(patterns, viper.GetStringSlice("http.includes")...)
 
-			t, err := template.New(errorDefinition.Name).Funcs(template.FuncMap{"isset": model.IsSet}).ParseFS(fsRoot, patterns...)
-			if err != nil {
-				slog.Error(fmt.Sprintf("cannot create template %s", errorDefinition.Name), KeyError, err, KeyComponent, ComponentService)
-				return nil, err
+			ctx := ErrorPageContext{
+				Model:      &page,
+				Status:     pe.ResponseCode,
+				StatusText: http.StatusText(pe.ResponseCode),
+				Data:       data,
+				Site:       viper.GetStringMap("http.site"),
 			}
-			var buf bytes.Buffer
-			err = t.Execute(&buf, &pe.Data)
-			if err != nil {
-				slog.Error(fmt.Sprintf("cannot render template %s", errorDefinition.Name), KeyError, err, KeyComponent, ComponentService)
-				return nil, err
+			if r != nil {
+				ctx.Path = strings.TrimPrefix(r.URL.Path, "/")
+				ctx.RequestPath = r.URL.Path
+				ctx.RequestID, _ = r.Context().Value(requestIDKey).(string)
+			}
+			if controllers.Debug && pe.Err != nil {
+				ctx.Error = pe.Err.Error()
+			}
+
+			_, _, _, buf, renderError := ctx.RenderRequest(controllers.Debug, r, ctx)
+			var templateError *model.TemplateError
+			if renderError != nil && errors.As(renderError.Err, &templateError) && templateError.Phase == "execute" {
+				_, _, _, legacy, legacyError := page.RenderRequest(controllers.Debug, r, data)
+				if legacyError == nil {
+					slog.Warn(fmt.Sprintf("template %s is rendered with the data of the error, as it fails with the error page context", errorDefinition.Name), KeyError, renderError.Err, KeyComponent, ComponentService)
+					return legacy.Bytes(), nil
+				}
+			}
+			if renderError != nil {
+				slog.Error(fmt.Sprintf("cannot render template %s", errorDefinition.Name), KeyError, renderError.Err, KeyComponent, ComponentService)
+				return nil, renderError.Err
 			}
 
 			return buf.Bytes(), nil
@@ -397,29 +605,77 @@ func GetErrorPageContent(pe model.ProcessingError) ([]byte, error) {
 			if errorDefinition.IsDefault {
 				return errorPageFiles.ReadFile("errorPages/" + errorDefinition.Name)
 			} else {
-				return staticFiles.ReadFile(errorDefinition.Name)
+				return readStaticErrorPage(errorDefinition.Name)
 			}
 		}
 	}
 	return nil, nil
 }
 
-func staticFileExists(fileName string) bool {
-	useEmbedded := viper.GetBool("http.content.useEmbedded")
-	var fsRoot fs.FS
-	fsRoot, _ = fs.Sub(staticFiles, viper.GetString("http.content.staticDirectory"))
+// readStaticErrorPage reads an error page from the static content, so that
+// pages in the overlay directories override embedded ones. Pages not found
+// there are read from the embedded file system by their full name, as
+// configurations written before the overlay give it.
+func readStaticErrorPage(name string) ([]byte, error) {
+	b, err := fs.ReadFile(staticRoot(), strings.TrimPrefix(name, "/"))
+	if errors.Is(err, fs.ErrNotExist) && viper.GetBool("http.content.useEmbedded") {
+		return staticFiles.ReadFile(name)
+	}
+	return b, err
+}
+
+// notModified reports whether the conditional request headers match the
+// ETag or Last-Modified response headers, so that the body can be omitted.
+func notModified(r *http.Request, h http.Header) bool {
+	if match := r.Header.Get("If-None-Match"); match != "" {
+		etag := strings.TrimPrefix(h.Get("ETag"), "W/")
+		if etag == "" {
+			return false
+		}
+		for _, candidate := range strings.Split(match, ",") {
+			candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+			if candidate == "*" || candidate == etag {
+				return true
+			}
+		}
+		return false
+	}
+
+	since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+	modified, err := http.ParseTime(h.Get("Last-Modified"))
+	if err != nil {
+		return false
+	}
+	return !modified.After(since)
+}
 
-	if !useEmbedded {
-		fsRoot = os.DirFS(viper.GetString("http.content.staticDirectory"))
+// templatesRoot returns the file system holding the templates, either the
+// embedded one or the templates directory on disk, with the directories of
+// http.content.overlay.templates layered on top.
+func templatesRoot() fs.FS {
+	directory := viper.GetString("http.content.templatesDirectory")
+	base, name := fs.FS(os.DirFS(directory)), directory
+	if viper.GetBool("http.content.useEmbedded") {
+		base, _ = fs.Sub(templates, directory)
+		name = "embedded"
 	}
+	return overlay("templates", base, name, viper.GetStringSlice("http.content.overlay.templates"))
+}
 
-	var static = http.FS(fsRoot)
-	f, err := static.Open(fileName)
-	if err == nil {
-		_ = f.Close()
-		return true
+// staticRoot returns the file system holding the static content, either the
+// embedded one or the static directory on disk, with the directories of
+// http.content.overlay.static layered on top.
+func staticRoot() fs.FS {
+	directory := viper.GetString("http.content.staticDirectory")
+	base, name := fs.FS(os.DirFS(directory)), directory
+	if viper.GetBool("http.content.useEmbedded") {
+		base, _ = fs.Sub(staticFiles, directory)
+		name = "embedded"
 	}
-	return false
+	return overlay("static", base, name, viper.GetStringSlice("http.content.overlay.static"))
 }
 
 // Initializes an OTLP exporter, and configures the corresponding trace and
@@ -0,0 +1,185 @@
+package pepper
+
+import (
+	"github.com/iktech/pepper/model"
+	"github.com/spf13/viper"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNotModified(t *testing.T) {
+	const (
+		modified = "Mon, 02 Mar 2026 10:00:00 GMT"
+		earlier  = "Sun, 01 Mar 2026 10:00:00 GMT"
+		later    = "Tue, 03 Mar 2026 10:00:00 GMT"
+	)
+	tests := []struct {
+		name     string
+		request  map[string]string
+		response map[string]string
+		want     bool
+	}{
+		{name: "unconditional", response: map[string]string{"ETag": `"abc"`}, want: false},
+		{name: "matching etag", request: map[string]string{"If-None-Match": `"abc"`}, response: map[string]string{"ETag": `"abc"`}, want: true},
+		{name: "one of several etags", request: map[string]string{"If-None-Match": `"x", "abc"`}, response: map[string]string{"ETag": `"abc"`}, want: true},
+		{name: "weak etag", request: map[string]string{"If-None-Match": `W/"abc"`}, response: map[string]string{"ETag": `"abc"`}, want: true},
+		{name: "compressed weak etag", request: map[string]string{"If-None-Match": `"abc"`}, response: map[string]string{"ETag": `W/"abc"`}, want: true},
+		{name: "wildcard", request: map[string]string{"If-None-Match": "*"}, response: map[string]string{"ETag": `"abc"`}, want: true},
+		{name: "other etag", request: map[string]string{"If-None-Match": `"xyz"`}, response: map[string]string{"ETag": `"abc"`}, want: false},
+		{name: "no etag", request: map[string]string{"If-None-Match": `"abc"`}, want: false},
+		{name: "etag takes precedence", request: map[string]string{"If-None-Match": `"xyz"`, "If-Modified-Since": later}, response: map[string]string{"ETag": `"abc"`, "Last-Modified": modified}, want: false},
+		{name: "not modified since", request: map[string]string{"If-Modified-Since": modified}, response: map[string]string{"Last-Modified": modified}, want: true},
+		{name: "modified before later date", request: map[string]string{"If-Modified-Since": later}, response: map[string]string{"Last-Modified": modified}, want: true},
+		{name: "modified since", request: map[string]string{"If-Modified-Since": earlier}, response: map[string]string{"Last-Modified": modified}, want: false},
+		{name: "invalid date", request: map[string]string{"If-Modified-Since": "yesterday"}, response: map[string]string{"Last-Modified": modified}, want: false},
+		{name: "no last modified", request: map[string]string{"If-Modified-Since": modified}, want: false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/feed.xml", nil)
+		for name, value := range tt.request {
+			r.Header.Set(name, value)
+		}
+		h := make(http.Header)
+		for name, value := range tt.response {
+			h.Set(name, value)
+		}
+		if got := notModified(r, h); got != tt.want {
+			t.Errorf("%s: notModified() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestStaticErrorPageOverlay(t *testing.T) {
+	t.Cleanup(viper.Reset)
+	previous := ErrorPages
+	t.Cleanup(func() { ErrorPages = previous })
+
+	static, override := t.TempDir(), t.TempDir()
+	writeFile(t, filepath.Join(static, "404.html"), "static 404")
+	writeFile(t, filepath.Join(static, "410.html"), "static 410")
+	writeFile(t, filepath.Join(override, "404.html"), "override 404")
+
+	viper.Set("http.content.useEmbedded", false)
+	viper.Set("http.content.staticDirectory", static)
+	viper.Set("http.content.overlay.static", []string{override})
+	ErrorPages = map[int]*ErrorPageDefinition{
+		404: {Name: "404.html"},
+		410: {Name: "410.html"},
+		418: {Name: "418.html"},
+	}
+
+	tests := []struct {
+		code int
+		want string
+	}{
+		{404, "override 404"},
+		{410, "static 410"},
+	}
+	for _, tt := range tests {
+		b, err := GetErrorPageContent(model.ProcessingError{ResponseCode: tt.code})
+		if err != nil {
+			t.Errorf("%d: %v", tt.code, err)
+		}
+		if string(b) != tt.want {
+			t.Errorf("%d: error page = %q, want %q", tt.code, b, tt.want)
+		}
+	}
+	if _, err := GetErrorPageContent(model.ProcessingError{ResponseCode: 418}); err == nil {
+		t.Error("missing error page read without error")
+	}
+}
+
+func writeFile(t *testing.T, name, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(name, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTemplateErrorPageData(t *testing.T) {
+	previous := ErrorPages
+	t.Cleanup(func() { ErrorPages = previous })
+
+	templates := t.TempDir()
+	writeFile(t, filepath.Join(templates, "404.gohtml"), `{{ if .Status }}{{ .Status }} {{ .RequestPath }}{{ else }}{{ .Name }}{{ end }}`)
+	ErrorPages = map[int]*ErrorPageDefinition{
+		404: {Name: "404.gohtml", IsTemplate: true, Data: map[string]string{"Name": "default"}},
+	}
+
+	tests := []struct {
+		name   string
+		legacy bool
+		data   interface{}
+		want   string
+	}{
+		{name: "context", want: "404 /missing"},
+		{name: "legacy", legacy: true, data: map[string]string{"Name": "error"}, want: "error"},
+		{name: "legacy default data", legacy: true, want: "default"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Cleanup(viper.Reset)
+			viper.Set("http.content.useEmbedded", false)
+			viper.Set("http.content.templatesDirectory", templates)
+			viper.Set("http.errorPageLegacyData", tt.legacy)
+
+			r := httptest.NewRequest("GET", "/missing", nil)
+			b, err := GetRequestErrorPageContent(model.ProcessingError{ResponseCode: 404, Data: tt.data}, r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(b) != tt.want {
+				t.Errorf("error page = %q, want %q", b, tt.want)
+			}
+		})
+	}
+}
+
+func TestLegacyErrorPageTemplate(t *testing.T) {
+	t.Cleanup(viper.Reset)
+	previous := ErrorPages
+	t.Cleanup(func() { ErrorPages = previous })
+
+	templates := t.TempDir()
+	writeFile(t, filepath.Join(templates, "410.gohtml"), `<p>{{ .Foo }}</p>`)
+	writeFile(t, filepath.Join(templates, "500.gohtml"), `<p>{{ .Foo.Bar }}</p>`)
+	viper.Set("http.content.useEmbedded", false)
+	viper.Set("http.content.templatesDirectory", templates)
+	ErrorPages = map[int]*ErrorPageDefinition{
+		410: {Name: "410.gohtml", IsTemplate: true, Data: map[string]string{"Foo": "default"}},
+		500: {Name: "500.gohtml", IsTemplate: true},
+	}
+
+	type legacyData struct{ Foo string }
+	tests := []struct {
+		name string
+		code int
+		data interface{}
+		want string
+	}{
+		{name: "map", code: 410, data: map[string]interface{}{"Foo": "gone"}, want: "<p>gone</p>"},
+		{name: "struct", code: 410, data: legacyData{Foo: "gone"}, want: "<p>gone</p>"},
+		{name: "default data", code: 410, want: "<p>default</p>"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/missing", nil)
+		b, err := GetRequestErrorPageContent(model.ProcessingError{ResponseCode: tt.code, Data: tt.data}, r)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if string(b) != tt.want {
+			t.Errorf("%s: error page = %q, want %q", tt.name, b, tt.want)
+		}
+	}
+
+	if _, err := GetErrorPageContent(model.ProcessingError{ResponseCode: 500, Data: "no Foo"}); err == nil {
+		t.Error("template failing with either data rendered")
+	}
+}
@@ -0,0 +1,303 @@
+package pepper
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"github.com/iktech/pepper/controllers"
+	"github.com/iktech/pepper/model"
+	"github.com/spf13/viper"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const sitemapNamespace = "http://www.sitemaps.org/schemas/sitemap/0.9"
+
+// SitemapRoute overrides the sitemap settings of a single route or static
+// page, given by its path without the leading slash.
+type SitemapRoute struct {
+	Path       string  `mapstructure:"path"`
+	Priority   float64 `mapstructure:"priority"`
+	ChangeFreq string  `mapstructure:"changefreq"`
+	Exclude    bool    `mapstructure:"exclude"`
+}
+
+type sitemapURL struct {
+	Loc        string `xml:"loc"`
+	LastMod    string `xml:"lastmod,omitempty"`
+	ChangeFreq string `xml:"changefreq,omitempty"`
+	Priority   string `xml:"priority,omitempty"`
+}
+
+type sitemapURLSet struct {
+	XMLName xml.Name     `xml:"urlset"`
+	Xmlns   string       `xml:"xmlns,attr"`
+	URLs    []sitemapURL `xml:"url"`
+}
+
+type sitemapReference struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod,omitempty"`
+}
+
+type sitemapIndex struct {
+	XMLName  xml.Name           `xml:"sitemapindex"`
+	Xmlns    string             `xml:"xmlns,attr"`
+	Sitemaps []sitemapReference `xml:"sitemap"`
+}
+
+// sitemap is the controller serving sitemap.xml and, for sites with more
+// URLs than fit into a single sitemap, its numbered parts.
+type sitemap struct {
+	routerMap map[string]controllers.Controller
+	static    fs.FS
+	routes    map[string]SitemapRoute
+	maxURLs   int
+	part      int
+	pages     []sitemapEntry
+	protected func(string) bool
+}
+
+// addSitemap registers sitemap.xml and its parts in the router map unless
+// the application defines its own. The pages are listed once, when the
+// sitemap is added, so that the router map has to be complete by then.
+// Pages for which protected returns true are not listed.
+func addSitemap(routerMap map[string]controllers.Controller, static fs.FS, protected func(string) bool) {
+	if _, found := routerMap["sitemap.xml"]; found {
+		slog.Info("sitemap.xml is served by a custom controller", KeyComponent, ComponentService)
+		return
+	}
+	if !siteURLKnown() {
+		slog.Warn("sitemap.xml is not served as http.site.baseUrl is not set", KeyComponent, ComponentService)
+		return
+	}
+
+	var routes []SitemapRoute
+	if err := viper.UnmarshalKey("http.sitemap.routes", &routes); err != nil {
+		slog.Error("cannot read sitemap routes", KeyError, err, KeyComponent, ComponentService)
+		return
+	}
+
+	s := sitemap{
+		routerMap: routerMap,
+		static:    static,
+		routes:    make(map[string]SitemapRoute),
+		maxURLs:   viper.GetInt("http.sitemap.maxUrls"),
+		protected: protected,
+	}
+	if s.maxURLs <= 0 {
+		s.maxURLs = 50000
+	}
+	for _, route := range routes {
+		s.routes[strings.Trim(route.Path, "/")] = route
+	}
+
+	s.pages = s.entries()
+	parts := (len(s.pages) + s.maxURLs - 1) / s.maxURLs
+	routerMap["sitemap.xml"] = s
+	for i := 1; parts > 1 && i <= parts; i++ {
+		part := s
+		part.part = i
+		routerMap[sitemapPart(i)] = part
+	}
+}
+
+func (s sitemap) Handle(r *http.Request) (int, string, string, *bytes.Buffer, *model.ProcessingError) {
+	base := SiteURL(r)
+	entries := s.pages
+	parts := (len(entries) + s.maxURLs - 1) / s.maxURLs
+
+	var document interface{}
+	switch {
+	case s.part == 0 && parts > 1:
+		index := sitemapIndex{Xmlns: sitemapNamespace}
+		for i := 1; i <= parts; i++ {
+			index.Sitemaps = append(index.Sitemaps, sitemapReference{
+				Loc:     base + "/" + sitemapPart(i),
+				LastMod: lastModified(entries[(i-1)*s.maxURLs : min(i*s.maxURLs, len(entries))]),
+			})
+		}
+		document = index
+	case s.part == 0:
+		document = s.urlSet(base, entries)
+	case s.part <= parts:
+		document = s.urlSet(base, entries[(s.part-1)*s.maxURLs:min(s.part*s.maxURLs, len(entries))])
+	default:
+		return 0, "", "", nil, &model.ProcessingError{ResponseCode: 404}
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	if err := xml.NewEncoder(&buf).Encode(document); err != nil {
+		slog.Error("cannot encode sitemap", KeyError, err, KeyComponent, ComponentService)
+		return 0, "", "", nil, &model.ProcessingError{ResponseCode: 500}
+	}
+
+	return 200, "", "application/xml; charset=utf-8", &buf, nil
+}
+
+type sitemapEntry struct {
+	path    string
+	options controllers.SitemapOptions
+	lastMod time.Time
+}
+
+// entries lists the public pages of the site: the routes of the router map
+// which render HTML and the HTML files of the static content.
+func (s sitemap) entries() []sitemapEntry {
+	var entries []sitemapEntry
+	for key, controller := range s.routerMap {
+		if _, ok := controller.(sitemap); ok || !isPage(key) || isNoIndex(robotsTag(controller, key)) || s.protected(key) {
+			continue
+		}
+
+		var e sitemapEntry
+		e.path = key
+		if c, ok := controller.(controllers.Sitemapped); ok {
+			e.options = c.SitemapOptions()
+		}
+		if c, ok := controller.(controllers.Modified); ok {
+			e.lastMod = c.LastModified()
+		}
+		entries = append(entries, e)
+	}
+
+	errorPages := make(map[string]bool)
+	for _, page := range ErrorPages {
+		errorPages[page.Name] = true
+	}
+
+	if s.static != nil && viper.GetBool("http.sitemap.static") {
+		_ = fs.WalkDir(s.static, ".", func(name string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() || !isPage(name) || errorPages[name] {
+				return nil
+			}
+
+			key := name
+			if path.Base(name) == "index.html" {
+				key = strings.TrimPrefix(path.Dir(name), ".")
+			}
+			if _, found := s.routerMap[key]; found || isNoIndex(robotsFor(key)) || s.protected(key) {
+				return nil
+			}
+
+			e := sitemapEntry{path: key}
+			if key != name && key != "" {
+				e.path = key + "/"
+			}
+			if info, err := d.Info(); err == nil {
+				e.lastMod = info.ModTime()
+			}
+			entries = append(entries, e)
+			return nil
+		})
+	}
+
+	filtered := entries[:0]
+	for _, e := range entries {
+		if route, found := s.routes[strings.TrimSuffix(e.path, "/")]; found {
+			if route.Priority != 0 {
+				e.options.Priority = route.Priority
+			}
+			if route.ChangeFreq != "" {
+				e.options.ChangeFreq = route.ChangeFreq
+			}
+			if route.Exclude {
+				e.options.Exclude = true
+			}
+		}
+		if !e.options.Exclude {
+			filtered = append(filtered, e)
+		}
+	}
+
+	sort.Slice(filtered, func(i, j int) bool {
+		return filtered[i].path < filtered[j].path
+	})
+	return filtered
+}
+
+func (s sitemap) urlSet(base string, entries []sitemapEntry) sitemapURLSet {
+	set := sitemapURLSet{Xmlns: sitemapNamespace}
+	for _, e := range entries {
+		u := sitemapURL{
+			Loc:        base + "/" + e.path,
+			ChangeFreq: e.options.ChangeFreq,
+		}
+		if !e.lastMod.IsZero() {
+			u.LastMod = e.lastMod.UTC().Format(time.RFC3339)
+		}
+		if e.options.Priority > 0 {
+			u.Priority = strconv.FormatFloat(e.options.Priority, 'f', -1, 64)
+		}
+		set.URLs = append(set.URLs, u)
+	}
+	return set
+}
+
+// SiteURL returns the base URL of the site without the trailing slash, taken
+// from http.site.baseUrl. If that is not set, the URL is derived from the
+// Host, X-Forwarded-Host and X-Forwarded-Proto headers, but only with
+// http.trustForwardedFor, since clients could otherwise put any host into
+// sitemaps and feeds. The URL is empty if it cannot be determined.
+func SiteURL(r *http.Request) string {
+	if base := viper.GetString("http.site.baseUrl"); base != "" {
+		return strings.TrimSuffix(base, "/")
+	}
+	if !viper.GetBool("http.trustForwardedFor") {
+		return ""
+	}
+
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto == "http" || proto == "https" {
+		scheme = proto
+	}
+	host := r.Host
+	if forwarded := r.Header.Get("X-Forwarded-Host"); forwarded != "" {
+		host, _, _ = strings.Cut(forwarded, ",")
+		host = strings.TrimSpace(host)
+	}
+	return scheme + "://" + host
+}
+
+// siteURLKnown reports whether SiteURL can determine the base URL of the
+// site, which documents listing absolute URLs require.
+func siteURLKnown() bool {
+	return viper.GetString("http.site.baseUrl") != "" || viper.GetBool("http.trustForwardedFor")
+}
+
+func lastModified(entries []sitemapEntry) string {
+	var latest time.Time
+	for _, e := range entries {
+		if e.lastMod.After(latest) {
+			latest = e.lastMod
+		}
+	}
+	if latest.IsZero() {
+		return ""
+	}
+	return latest.UTC().Format(time.RFC3339)
+}
+
+func sitemapPart(i int) string {
+	return fmt.Sprintf("sitemap-%d.xml", i)
+}
+
+// isPage reports whether the path refers to an HTML page rather than to a
+// feed, an asset or another document.
+func isPage(name string) bool {
+	switch strings.ToLower(path.Ext(name)) {
+	case "", ".html", ".htm":
+		return true
+	}
+	return false
+}
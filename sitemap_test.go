@@ -0,0 +1,118 @@
+package pepper
+
+import (
+	"crypto/tls"
+	"github.com/iktech/pepper/controllers"
+	"github.com/iktech/pepper/model"
+	"github.com/spf13/viper"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"testing/fstest"
+)
+
+func TestSiteURL(t *testing.T) {
+	tests := []struct {
+		name    string
+		baseURL string
+		trusted bool
+		tls     bool
+		headers map[string]string
+		want    string
+	}{
+		{name: "configured", baseURL: "https://example.com/", want: "https://example.com"},
+		{name: "configured ignores headers", baseURL: "https://example.com", headers: map[string]string{"X-Forwarded-Host": "evil.test", "X-Forwarded-Proto": "http"}, want: "https://example.com"},
+		{name: "untrusted", headers: map[string]string{"X-Forwarded-Host": "evil.test"}, want: ""},
+		{name: "trusted host", trusted: true, want: "http://site.test"},
+		{name: "trusted tls", trusted: true, tls: true, want: "https://site.test"},
+		{name: "trusted forwarded", trusted: true, headers: map[string]string{"X-Forwarded-Host": "www.site.test, proxy.internal", "X-Forwarded-Proto": "https"}, want: "https://www.site.test"},
+		{name: "trusted unexpected proto", trusted: true, headers: map[string]string{"X-Forwarded-Proto": "javascript"}, want: "http://site.test"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Cleanup(viper.Reset)
+			viper.Set("http.site.baseUrl", tt.baseURL)
+			viper.Set("http.trustForwardedFor", tt.trusted)
+
+			r := httptest.NewRequest("GET", "/sitemap.xml", nil)
+			r.Host = "site.test"
+			if tt.tls {
+				r.TLS = &tls.ConnectionState{}
+			}
+			for name, value := range tt.headers {
+				r.Header.Set(name, value)
+			}
+			if got := SiteURL(r); got != tt.want {
+				t.Errorf("SiteURL() = %q, want %q", got, tt.want)
+			}
+			if got, want := siteURLKnown(), tt.baseURL != "" || tt.trusted; got != want {
+				t.Errorf("siteURLKnown() = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestSitemapPriority(t *testing.T) {
+	tests := []struct {
+		priority float64
+		want     string
+	}{
+		{0, ""},
+		{0.25, "0.25"},
+		{0.5, "0.5"},
+		{1, "1"},
+	}
+	for _, tt := range tests {
+		set := sitemap{}.urlSet("https://example.com", []sitemapEntry{{path: "about", options: controllers.SitemapOptions{Priority: tt.priority}}})
+		if got := set.URLs[0].Priority; got != tt.want {
+			t.Errorf("priority %v = %q, want %q", tt.priority, got, tt.want)
+		}
+		if got := set.URLs[0].Loc; got != "https://example.com/about" {
+			t.Errorf("loc = %q, want https://example.com/about", got)
+		}
+	}
+}
+
+// protectedService returns a service protecting everything below admin by an
+// auth rule and the private static mount.
+func protectedService() Service {
+	return Service{
+		authRules: []authRule{
+			{AuthRule: AuthRule{Name: "admin", Prefix: "admin"}, protect: func(h http.Handler) http.Handler { return h }},
+			{AuthRule: AuthRule{Name: "public", Routes: []string{"blog/*"}, Scheme: AuthSchemeNone}},
+		},
+		mounts: []staticMount{{prefix: "private", protected: true}, {prefix: "docs"}},
+	}
+}
+
+func TestSitemapSkipsProtectedPages(t *testing.T) {
+	t.Cleanup(viper.Reset)
+	viper.Set("http.sitemap.static", true)
+
+	page := controllers.Model{Model: &model.Model{}}
+	s := sitemap{
+		routerMap: map[string]controllers.Controller{
+			"":            page,
+			"about":       page,
+			"admin":       page,
+			"admin/users": page,
+			"blog/post":   page,
+		},
+		static: fstest.MapFS{
+			"contact.html":       {Data: []byte("contact")},
+			"admin/help.html":    {Data: []byte("help")},
+			"private/index.html": {Data: []byte("private")},
+			"docs/index.html":    {Data: []byte("docs")},
+		},
+		protected: protectedService().protected,
+	}
+
+	var paths []string
+	for _, e := range s.entries() {
+		paths = append(paths, e.path)
+	}
+	if want := []string{"", "about", "blog/post", "contact.html", "docs/"}; !reflect.DeepEqual(paths, want) {
+		t.Errorf("sitemap paths = %q, want %q", paths, want)
+	}
+}
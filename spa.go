@@ -0,0 +1,104 @@
+package pepper
+
+import (
+	"github.com/spf13/viper"
+	"log/slog"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// SPAFallback serves the index file of a single-page application for the
+// paths below the prefix that match neither a route nor a static file, so
+// that client-side routing works on reload and for deep links.
+type SPAFallback struct {
+	Prefix       string `mapstructure:"prefix"`
+	Index        string `mapstructure:"index"`
+	CacheControl string `mapstructure:"cacheControl"`
+}
+
+// loadSPAFallbacks reads the fallbacks from http.spa, longest prefix first.
+// The index file of every fallback has to exist among the static files.
+func loadSPAFallbacks(static *staticFileHandler) []SPAFallback {
+	var fallbacks []SPAFallback
+	if err := viper.UnmarshalKey("http.spa", &fallbacks); err != nil {
+		slog.Error("cannot read single-page application fallbacks", KeyError, err, KeyComponent, ComponentService)
+		os.Exit(1)
+	}
+
+	for i := range fallbacks {
+		f := &fallbacks[i]
+		f.Prefix = strings.Trim(f.Prefix, "/")
+		f.Index = strings.TrimPrefix(f.Index, "/")
+		if f.Index == "" {
+			f.Index = path.Join(f.Prefix, "index.html")
+		}
+		if !static.exists(f.Index) {
+			slog.Error("index file of single-page application does not exist", "prefix", "/"+f.Prefix, "index", f.Index, KeyComponent, ComponentService)
+			os.Exit(1)
+		}
+		slog.Info("serving single-page application", "prefix", "/"+f.Prefix, "index", f.Index, KeyComponent, ComponentService)
+	}
+
+	sort.SliceStable(fallbacks, func(i, j int) bool {
+		return len(fallbacks[i].Prefix) > len(fallbacks[j].Prefix)
+	})
+	return fallbacks
+}
+
+// spaFallback returns the fallback for the path, if any. Paths with a file
+// extension are left to 404 as they refer to missing files rather than to
+// client-side routes.
+func (s Service) spaFallback(name string) (SPAFallback, bool) {
+	if path.Ext(name) != "" {
+		return SPAFallback{}, false
+	}
+	for _, f := range s.fallbacks {
+		if f.Prefix == "" || name == f.Prefix || strings.HasPrefix(name, f.Prefix+"/") {
+			return f, true
+		}
+	}
+	return SPAFallback{}, false
+}
+
+// serve responds with the index file of the application. The URL is
+// rewritten to the directory of the index file if it is called index.html,
+// as the file server redirects explicit requests for index.html.
+func (f SPAFallback) serve(static *staticFileHandler, w http.ResponseWriter, r *http.Request) {
+	u := *r.URL
+	u.Path = "/" + f.Index
+	if path.Base(f.Index) == "index.html" {
+		u.Path = strings.TrimSuffix(u.Path, "index.html")
+	}
+	index := new(http.Request)
+	*index = *r
+	index.URL = &u
+
+	if f.CacheControl != "" {
+		w.Header().Set("Cache-Control", f.CacheControl)
+	}
+	static.ServeHTTP(w, index)
+}
+
+// acceptsHTML reports whether the request explicitly accepts an HTML
+// document, as browsers do when navigating. Requests made by scripts, which
+// usually accept JSON or anything, are not answered with the application.
+func acceptsHTML(r *http.Request) bool {
+	for _, header := range r.Header.Values("Accept") {
+		for _, part := range strings.Split(header, ",") {
+			mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+			if err != nil || (mediaType != "text/html" && mediaType != "application/xhtml+xml") {
+				continue
+			}
+			if q, err := strconv.ParseFloat(params["q"], 64); err == nil && q == 0 {
+				continue
+			}
+			return true
+		}
+	}
+	return false
+}
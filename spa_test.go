@@ -0,0 +1,95 @@
+package pepper
+
+import (
+	"github.com/spf13/viper"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+)
+
+func TestAcceptsHTML(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   bool
+	}{
+		{"", false},
+		{"text/html", true},
+		{"text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8", true},
+		{"application/xhtml+xml", true},
+		{"application/json", false},
+		{"*/*", false},
+		{"text/*", false},
+		{"text/html;q=0", false},
+		{"text/html;q=0.1", true},
+		{"application/json, text/html;q=0.5", true},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/app/settings", nil)
+		if tt.accept != "" {
+			r.Header.Set("Accept", tt.accept)
+		}
+		if got := acceptsHTML(r); got != tt.want {
+			t.Errorf("acceptsHTML(%q) = %v, want %v", tt.accept, got, tt.want)
+		}
+	}
+}
+
+func TestSPAFallback(t *testing.T) {
+	t.Cleanup(viper.Reset)
+	previous := ErrorPages
+	t.Cleanup(func() { ErrorPages = previous })
+	ErrorPages = map[int]*ErrorPageDefinition{}
+
+	static := newStaticHandler(fstest.MapFS{
+		"index.html":       {Data: []byte("site")},
+		"app/index.html":   {Data: []byte("app")},
+		"app/main.js":      {Data: []byte("main")},
+		"admin/shell.html": {Data: []byte("admin")},
+	}, nil, true)
+	viper.Set("http.spa", []map[string]interface{}{
+		{"prefix": "/app/", "cacheControl": "no-cache"},
+		{"prefix": "admin", "index": "/admin/shell.html"},
+	})
+	s := Service{staticHandler: static, fallbacks: loadSPAFallbacks(static)}
+
+	tests := []struct {
+		name    string
+		path    string
+		accept  string
+		code    int
+		body    string
+		control string
+		vary    string
+	}{
+		{name: "deep link", path: "/app/settings/profile", accept: "text/html", code: http.StatusOK, body: "app", control: "no-cache", vary: "Accept"},
+		{name: "application directory", path: "/app/", accept: "text/html", code: http.StatusOK, body: "app", vary: "Accept"},
+		{name: "other index file", path: "/admin/users/1", accept: "text/html,*/*;q=0.8", code: http.StatusOK, body: "admin", vary: "Accept"},
+		{name: "existing asset", path: "/app/main.js", accept: "*/*", code: http.StatusOK, body: "main"},
+		{name: "missing asset", path: "/app/missing.js", accept: "text/html", code: http.StatusNotFound, vary: "Accept"},
+		{name: "script request", path: "/app/settings", accept: "application/json", code: http.StatusNotFound, vary: "Accept"},
+		{name: "any type", path: "/app/settings", accept: "*/*", code: http.StatusNotFound, vary: "Accept"},
+		{name: "html refused", path: "/app/settings", accept: "text/html;q=0", code: http.StatusNotFound, vary: "Accept"},
+		{name: "outside the prefix", path: "/application", accept: "text/html", code: http.StatusNotFound, vary: "Accept"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", tt.path, nil)
+		r.Header.Set("Accept", tt.accept)
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+
+		if w.Code != tt.code {
+			t.Errorf("%s: status %d, want %d", tt.name, w.Code, tt.code)
+			continue
+		}
+		if tt.body != "" && w.Body.String() != tt.body {
+			t.Errorf("%s: body = %q, want %q", tt.name, w.Body.String(), tt.body)
+		}
+		if got := w.Header().Get("Cache-Control"); got != tt.control {
+			t.Errorf("%s: Cache-Control = %q, want %q", tt.name, got, tt.control)
+		}
+		if got := w.Header().Get("Vary"); got != tt.vary {
+			t.Errorf("%s: Vary = %q, want %q", tt.name, got, tt.vary)
+		}
+	}
+}
@@ -0,0 +1,384 @@
+package pepper
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/fs"
+	"log/slog"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CacheRule sets the Cache-Control header of the static files matching the
+// pattern. Patterns without a slash match the file name, for example
+// "*.css"; patterns ending with "/**" match everything below a directory.
+type CacheRule struct {
+	Pattern string `mapstructure:"pattern"`
+	Control string `mapstructure:"control"`
+}
+
+// staticFileHandler serves static files with content based entity tags,
+// Last-Modified dates and Cache-Control headers.
+type staticFileHandler struct {
+	root          fs.FS
+	files         http.Handler
+	rules         []CacheRule
+	etags         sync.Map
+	assetPatterns []string
+	assetControl  string
+	precompressed bool
+	indexFiles    []string
+	listing       []string
+	missingIndex  int
+}
+
+// precompressedEncodings lists the content codings of precompressed variants
+// in order of preference, with the extension of the variant file.
+var precompressedEncodings = []struct {
+	encoding  string
+	extension string
+}{
+	{"br", ".br"},
+	{"gzip", ".gz"},
+}
+
+type etagEntry struct {
+	modTime time.Time
+	size    int64
+	etag    string
+}
+
+// modTimeFS reports a fixed modification time for files which have none, as
+// is the case for embedded files, so that Last-Modified can be sent.
+type modTimeFS struct {
+	fs.FS
+	modTime time.Time
+}
+
+type modTimeFile struct {
+	fs.File
+	modTime time.Time
+}
+
+type modTimeFileInfo struct {
+	fs.FileInfo
+	modTime time.Time
+}
+
+// newStaticHandler returns the handler serving the files of the root. The
+// entity tags of embedded files, which cannot change, are computed up front;
+// those of files on disk are computed on first request, so that startup does
+// not depend on the size of the directory.
+func newStaticHandler(root fs.FS, rules []CacheRule, embedded bool) *staticFileHandler {
+	root = modTimeFS{FS: root, modTime: buildTime()}
+	h := &staticFileHandler{
+		root:  root,
+		files: http.FileServer(http.FS(root)),
+		rules: rules,
+	}
+	if !embedded {
+		return h
+	}
+
+	count := 0
+	_ = fs.WalkDir(root, ".", func(name string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() && h.etag(name) != "" {
+			count++
+		}
+		return nil
+	})
+	slog.Info("computed entity tags of static files", "files", count, KeyComponent, ComponentService)
+
+	return h
+}
+
+func (h *staticFileHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/")
+	if name == "" || strings.HasSuffix(r.URL.Path, "/") {
+		if index, ok := h.index(name); ok {
+			// The file server serves index.html by itself but no other
+			// index file, so the request is rewritten to refer to it.
+			if path.Base(index) != "index.html" {
+				u := *r.URL
+				u.Path = "/" + index
+				indexRequest := new(http.Request)
+				*indexRequest = *r
+				indexRequest.URL = &u
+				r = indexRequest
+			}
+			name = index
+		}
+	}
+
+	control := h.cacheControl(name)
+	if original, ok := h.original(name); ok && !h.exists(name) {
+		u := *r.URL
+		u.Path = "/" + original
+		fingerprinted := new(http.Request)
+		*fingerprinted = *r
+		fingerprinted.URL = &u
+		r, name = fingerprinted, original
+		control = h.assetControl
+	}
+
+	if h.precompressed {
+		r, name = h.negotiate(w, r, name)
+	}
+
+	if etag := h.etag(name); etag != "" {
+		w.Header().Set("ETag", etag)
+	}
+	if control != "" && w.Header().Get("Cache-Control") == "" {
+		w.Header().Set("Cache-Control", control)
+	}
+
+	h.files.ServeHTTP(w, r)
+}
+
+// negotiate selects a precompressed variant of the file, such as app.js.br
+// next to app.js, if the client accepts its encoding. The variant is served
+// with the content type of the original file. Range requests are served
+// from the uncompressed file only, as ranges of the encoded body are of no
+// use to clients.
+func (h *staticFileHandler) negotiate(w http.ResponseWriter, r *http.Request, name string) (*http.Request, string) {
+	if r.Header.Get("Range") != "" {
+		return r, name
+	}
+
+	available := false
+	for _, e := range precompressedEncodings {
+		if !h.exists(name + e.extension) {
+			continue
+		}
+		available = true
+		if !acceptsEncoding(r, e.encoding) {
+			continue
+		}
+
+		contentType := mime.TypeByExtension(path.Ext(name))
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Content-Encoding", e.encoding)
+		addVary(w.Header(), "Accept-Encoding")
+
+		u := *r.URL
+		u.Path = "/" + name + e.extension
+		variant := new(http.Request)
+		*variant = *r
+		variant.URL = &u
+		return variant, name + e.extension
+	}
+
+	if available {
+		addVary(w.Header(), "Accept-Encoding")
+	}
+	return r, name
+}
+
+// index returns the first of the configured index files present in the
+// directory.
+func (h *staticFileHandler) index(directory string) (string, bool) {
+	for _, indexFile := range h.indexFiles {
+		name := path.Join(directory, indexFile)
+		if info, err := fs.Stat(h.root, name); err == nil && !info.IsDir() {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// forbidden returns the status of the response to a request for a directory
+// which has no index file and is not listed, or 0 if the request can be
+// served. Directory listings are disabled unless enabled for the directory.
+func (h *staticFileHandler) forbidden(urlPath string) int {
+	name := strings.TrimPrefix(path.Clean("/"+urlPath), "/")
+	dir := name
+	if dir == "" {
+		dir = "."
+	}
+	if info, err := fs.Stat(h.root, dir); err != nil || !info.IsDir() {
+		return 0
+	}
+	if _, ok := h.index(name); ok {
+		return 0
+	}
+	for _, pattern := range h.listing {
+		if matchPattern(pattern, name) {
+			return 0
+		}
+	}
+	return h.missingIndex
+}
+
+// exists reports whether the static file exists, either under its own name
+// or as the fingerprinted name of another file.
+func (h *staticFileHandler) exists(name string) bool {
+	f, err := http.FS(h.root).Open(path.Clean("/" + name))
+	if err == nil {
+		_ = f.Close()
+		return true
+	}
+	return false
+}
+
+// serves reports whether the request path refers to a static file, either
+// under its own name or by its fingerprinted name.
+func (h *staticFileHandler) serves(name string) bool {
+	if h.exists(name) {
+		return true
+	}
+	_, ok := h.original(name)
+	return ok
+}
+
+// etag returns the entity tag of the file, computed from its content. Tags
+// are cached and recomputed when the size or modification time changes.
+func (h *staticFileHandler) etag(name string) string {
+	info, err := fs.Stat(h.root, name)
+	if err != nil || info.IsDir() {
+		return ""
+	}
+
+	if v, ok := h.etags.Load(name); ok {
+		e := v.(etagEntry)
+		if e.size == info.Size() && e.modTime.Equal(info.ModTime()) {
+			return e.etag
+		}
+	}
+
+	f, err := h.root.Open(name)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	if _, err = io.Copy(hash, f); err != nil {
+		slog.Warn("cannot compute entity tag", "file", name, KeyError, err, KeyComponent, ComponentService)
+		return ""
+	}
+
+	etag := `"` + hex.EncodeToString(hash.Sum(nil))[:20] + `"`
+	h.etags.Store(name, etagEntry{modTime: info.ModTime(), size: info.Size(), etag: etag})
+	return etag
+}
+
+func (h *staticFileHandler) cacheControl(name string) string {
+	for _, rule := range h.rules {
+		if matchPattern(rule.Pattern, name) {
+			return rule.Control
+		}
+	}
+	return ""
+}
+
+// acceptsEncoding reports whether the Accept-Encoding header of the request
+// allows the content coding, taking zero quality values into account.
+func acceptsEncoding(r *http.Request, encoding string) bool {
+	wildcard := false
+	for _, header := range r.Header.Values("Accept-Encoding") {
+		for _, part := range strings.Split(header, ",") {
+			coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+			coding = strings.ToLower(strings.TrimSpace(coding))
+			if coding != encoding && coding != "*" {
+				continue
+			}
+
+			accepted := true
+			for _, param := range strings.Split(params, ";") {
+				if q, found := strings.CutPrefix(strings.TrimSpace(param), "q="); found {
+					if v, err := strconv.ParseFloat(q, 64); err == nil && v == 0 {
+						accepted = false
+					}
+				}
+			}
+			if coding == encoding {
+				return accepted
+			}
+			wildcard = accepted
+		}
+	}
+	return wildcard
+}
+
+// addVary adds the header name to the Vary header unless already listed.
+func addVary(h http.Header, name string) {
+	for _, value := range h.Values("Vary") {
+		for _, field := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(field), name) {
+				return
+			}
+		}
+	}
+	h.Add("Vary", name)
+}
+
+// matchPattern matches a path against a glob pattern. Patterns without a
+// slash match the base name, patterns ending with "/**" match by prefix.
+func matchPattern(pattern, name string) bool {
+	pattern = strings.TrimPrefix(pattern, "/")
+	if prefix, found := strings.CutSuffix(pattern, "/**"); found {
+		return name == prefix || strings.HasPrefix(name, prefix+"/")
+	}
+	if !strings.Contains(pattern, "/") {
+		name = path.Base(name)
+	}
+	matched, _ := path.Match(pattern, name)
+	return matched
+}
+
+// buildTime returns the modification time of the executable, which is used
+// as the modification time of embedded files.
+func buildTime() time.Time {
+	if executable, err := os.Executable(); err == nil {
+		if info, err := os.Stat(executable); err == nil {
+			return info.ModTime().Truncate(time.Second)
+		}
+	}
+	return time.Now().Truncate(time.Second)
+}
+
+func (m modTimeFS) Open(name string) (fs.File, error) {
+	f, err := m.FS.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return modTimeFile{File: f, modTime: m.modTime}, nil
+}
+
+func (f modTimeFile) Stat() (fs.FileInfo, error) {
+	info, err := f.File.Stat()
+	if err != nil || !info.ModTime().IsZero() {
+		return info, err
+	}
+	return modTimeFileInfo{FileInfo: info, modTime: f.modTime}, nil
+}
+
+// Seek and ReadDir are passed through so that http.FS can serve ranges and
+// list directories.
+func (f modTimeFile) Seek(offset int64, whence int) (int64, error) {
+	if s, ok := f.File.(io.Seeker); ok {
+		return s.Seek(offset, whence)
+	}
+	return 0, fs.ErrInvalid
+}
+
+func (f modTimeFile) ReadDir(n int) ([]fs.DirEntry, error) {
+	if d, ok := f.File.(fs.ReadDirFile); ok {
+		return d.ReadDir(n)
+	}
+	return nil, fs.ErrInvalid
+}
+
+func (i modTimeFileInfo) ModTime() time.Time {
+	return i.modTime
+}
@@ -0,0 +1,157 @@
+package pepper
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func countETags(h *staticFileHandler) int {
+	n := 0
+	h.etags.Range(func(_, _ interface{}) bool {
+		n++
+		return true
+	})
+	return n
+}
+
+func TestStaticETagsOnDiskAreLazy(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "app.js")
+	if err := os.WriteFile(file, []byte("one"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	h := newStaticHandler(os.DirFS(dir), nil, false)
+	if n := countETags(h); n != 0 {
+		t.Fatalf("%d entity tags computed at startup, want 0", n)
+	}
+
+	first := h.etag("app.js")
+	if first == "" {
+		t.Fatal("no entity tag for app.js")
+	}
+	if n := countETags(h); n != 1 {
+		t.Fatalf("%d entity tags cached, want 1", n)
+	}
+	if again := h.etag("app.js"); again != first {
+		t.Errorf("cached entity tag = %s, want %s", again, first)
+	}
+
+	if err := os.WriteFile(file, []byte("two!"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(file, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if changed := h.etag("app.js"); changed == first || changed == "" {
+		t.Errorf("entity tag after change = %s, want a new tag", changed)
+	}
+	if tag := h.etag("missing.js"); tag != "" {
+		t.Errorf("entity tag of a missing file = %s, want none", tag)
+	}
+}
+
+func TestStaticETagsEmbeddedArePrecomputed(t *testing.T) {
+	root := fstest.MapFS{
+		"app.js":       {Data: []byte("app")},
+		"css/site.css": {Data: []byte("body{}")},
+	}
+	h := newStaticHandler(root, nil, true)
+	if n := countETags(h); n != 2 {
+		t.Errorf("%d entity tags computed at startup, want 2", n)
+	}
+}
+
+func TestAcceptsEncoding(t *testing.T) {
+	tests := []struct {
+		header   string
+		encoding string
+		want     bool
+	}{
+		{"", "gzip", false},
+		{"gzip", "gzip", true},
+		{"gzip, br", "br", true},
+		{"GZIP", "gzip", true},
+		{"deflate", "gzip", false},
+		{"br;q=0", "br", false},
+		{"br;q=0.0", "br", false},
+		{"br;q=0.5", "br", true},
+		{"*", "br", true},
+		{"*;q=0", "br", false},
+		{"*, br;q=0", "br", false},
+		{"br;q=0, *", "br", false},
+		{"gzip;q=0, *", "br", true},
+		{"identity", "gzip", false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/app.js", nil)
+		if tt.header != "" {
+			r.Header.Set("Accept-Encoding", tt.header)
+		}
+		if got := acceptsEncoding(r, tt.encoding); got != tt.want {
+			t.Errorf("acceptsEncoding(%q, %s) = %v, want %v", tt.header, tt.encoding, got, tt.want)
+		}
+	}
+}
+
+func TestStaticIndexFiles(t *testing.T) {
+	previous := ErrorPages
+	t.Cleanup(func() { ErrorPages = previous })
+	ErrorPages = map[int]*ErrorPageDefinition{}
+
+	root := fstest.MapFS{
+		"index.html":         {Data: []byte("home")},
+		"docs/index.htm":     {Data: []byte("docs")},
+		"blog/index.html":    {Data: []byte("blog")},
+		"blog/index.htm":     {Data: []byte("legacy")},
+		"public/files/a.txt": {Data: []byte("a")},
+		"private/secret.txt": {Data: []byte("secret")},
+	}
+
+	tests := []struct {
+		name         string
+		path         string
+		missingIndex int
+		code         int
+		body         string
+	}{
+		{name: "root index", path: "/", code: http.StatusOK, body: "home"},
+		{name: "other index file", path: "/docs/", code: http.StatusOK, body: "docs"},
+		{name: "first index file", path: "/blog/", code: http.StatusOK, body: "blog"},
+		{name: "directory without slash", path: "/docs", code: http.StatusMovedPermanently},
+		{name: "listing allowed", path: "/public/files/", code: http.StatusOK, body: "a.txt"},
+		{name: "not found", path: "/private/", missingIndex: http.StatusNotFound, code: http.StatusNotFound},
+		{name: "forbidden", path: "/private/", missingIndex: http.StatusForbidden, code: http.StatusForbidden},
+		{name: "file in forbidden directory", path: "/private/secret.txt", missingIndex: http.StatusForbidden, code: http.StatusOK, body: "secret"},
+	}
+	for _, tt := range tests {
+		h := newStaticHandler(root, nil, true)
+		h.indexFiles = []string{"index.html", "index.htm"}
+		h.listing = []string{"public/**"}
+		h.missingIndex = tt.missingIndex
+		if h.missingIndex == 0 {
+			h.missingIndex = http.StatusNotFound
+		}
+		s := Service{staticHandler: h}
+
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest("GET", tt.path, nil))
+		if w.Code != tt.code {
+			t.Errorf("%s: status %d, want %d", tt.name, w.Code, tt.code)
+			continue
+		}
+		if !strings.Contains(w.Body.String(), tt.body) {
+			t.Errorf("%s: body = %q, want %q", tt.name, w.Body.String(), tt.body)
+		}
+		if strings.Contains(w.Body.String(), "secret.txt") {
+			t.Errorf("%s: directory listed", tt.name)
+		}
+	}
+}